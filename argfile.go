@@ -0,0 +1,125 @@
+package flag
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const argFilePrefix = "@"
+
+// expandArgFiles replace '@file' arguments with arguments read from file, reading is a stack of
+// absolute paths to detect cyclic reference. The first argument is command name and arguments after
+// '--' hint are kept as is.
+func expandArgFiles(args []string, reading []string) ([]string, error) {
+	var expanded []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case i == 0 && reading == nil:
+		case arg == "--*":
+			return append(expanded, args[i:]...), nil
+		case arg == "--":
+			if i+1 < len(args) {
+				expanded = append(expanded, arg)
+				i++
+				arg = args[i]
+			}
+		case strings.HasPrefix(arg, argFilePrefix) && len(arg) > len(argFilePrefix):
+			fileArgs, err := readArgFile(arg[len(argFilePrefix):], reading)
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, fileArgs...)
+			continue
+		}
+		expanded = append(expanded, arg)
+	}
+	return expanded, nil
+}
+
+func readArgFile(path string, reading []string) ([]string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, newErrorf(errInvalidValue, "invalid argument file: %s, %s", path, err.Error())
+	}
+	for _, p := range reading {
+		if p == abs {
+			return nil, newErrorf(errInvalidValue, "cyclic argument file: %s -> %s", strings.Join(reading, " -> "), abs)
+		}
+	}
+	content, err := ioutil.ReadFile(abs)
+	if err != nil {
+		return nil, newErrorf(errInvalidValue, "read argument file failed: %s", err.Error())
+	}
+	args, err := splitArgs(string(content))
+	if err != nil {
+		return nil, newErrorf(errInvalidValue, "parse argument file failed: %s, %s", path, err.Error())
+	}
+	return expandArgFiles(args, append(reading, abs))
+}
+
+// splitArgs split content to arguments like shell, arguments are separated by whitespaces including
+// newlines, single quotes keep content literally, backslash escapes the next character except inside
+// single quotes, inside double quotes it only escapes '"', '\' and newline. Backslash at line end
+// continues the line, and '#' at the beginning of argument starts a comment till line end.
+func splitArgs(content string) ([]string, error) {
+	var (
+		args  []string
+		buf   bytes.Buffer
+		quote byte
+		inArg bool
+	)
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case quote == '\'':
+			if c == quote {
+				quote = 0
+			} else {
+				buf.WriteByte(c)
+			}
+		case quote == '"':
+			switch {
+			case c == quote:
+				quote = 0
+			case c == '\\' && i+1 < len(content) && content[i+1] == '\n':
+				i++
+			case c == '\\' && i+1 < len(content) && (content[i+1] == '"' || content[i+1] == '\\'):
+				i++
+				buf.WriteByte(content[i])
+			default:
+				buf.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == '\\' && i+1 < len(content):
+			i++
+			if content[i] != '\n' {
+				buf.WriteByte(content[i])
+				inArg = true
+			}
+		case c == '#' && !inArg:
+			for i+1 < len(content) && content[i+1] != '\n' {
+				i++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, buf.String())
+				buf.Reset()
+				inArg = false
+			}
+		default:
+			buf.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, newErrorf(errInvalidValue, "unclosed quote %c in arguments", quote)
+	}
+	if inArg {
+		args = append(args, buf.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,253 @@
+package flag
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// ParseJSON load flag values from json file, top-level keys are mapped to flags and objects are
+// mapped to subsets, keys are flag names and the leading dashes could be omitted, arrays are mapped
+// to slice flags. It should be called before Parse, values from command line and environment
+// take precedence over it, and it takes precedence over default value.
+func (f *FlagSet) ParseJSON(path string) error {
+	return f.parseConfig(path, readJSON)
+}
+
+// ParseTOML load flag values from toml file like ParseJSON, tables are mapped to subsets.
+func (f *FlagSet) ParseTOML(path string) error {
+	return f.parseConfig(path, readTOML)
+}
+
+// ParseYAML load flag values from yaml file like ParseJSON, mappings are mapped to subsets.
+func (f *FlagSet) ParseYAML(path string) error {
+	return f.parseConfig(path, readYAML)
+}
+
+// ParseConfigFile load flag values from config file, the format is detected by file extension,
+// .json, .toml, .yaml and .yml are supported.
+func (f *FlagSet) ParseConfigFile(path string) error {
+	return f.parseConfig(path, f.readConfigFile)
+}
+
+func (f *FlagSet) parseConfig(path string, read func(string) (map[string]interface{}, error)) error {
+	vals, err := read(path)
+	if err == nil {
+		err = loadConfig(f, nil, vals, defaultConfigRank)
+	}
+	return f.errorHandling.handle(err)
+}
+
+func (f *FlagSet) readConfigFile(path string) (map[string]interface{}, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return readJSON(path)
+	case ".toml":
+		return readTOML(path)
+	case ".yaml", ".yml":
+		return readYAML(path)
+	default:
+		return nil, newErrorf(errInvalidValue, "unsupported config file format: %s", path)
+	}
+}
+
+func readJSON(path string) (map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, newErrorf(errInvalidValue, "read config file failed: %s", err.Error())
+	}
+	var vals map[string]interface{}
+	err = json.Unmarshal(content, &vals)
+	if err != nil {
+		return nil, newErrorf(errInvalidValue, "parse json file failed: %s, %s", path, err.Error())
+	}
+	return vals, nil
+}
+
+func readTOML(path string) (map[string]interface{}, error) {
+	var vals map[string]interface{}
+	_, err := toml.DecodeFile(path, &vals)
+	if err != nil {
+		return nil, newErrorf(errInvalidValue, "parse toml file failed: %s, %s", path, err.Error())
+	}
+	return vals, nil
+}
+
+func readYAML(path string) (map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, newErrorf(errInvalidValue, "read config file failed: %s", err.Error())
+	}
+	var vals map[interface{}]yamlValue
+	err = yaml.Unmarshal(content, &vals)
+	if err != nil {
+		return nil, newErrorf(errInvalidValue, "parse yaml file failed: %s, %s", path, err.Error())
+	}
+	return normalizeYAML(vals).(map[string]interface{}), nil
+}
+
+// yamlValue is the yaml value decoded with the original text of scalar. YAML 1.1 resolves plain
+// scalars such as yes, on and 1.0 to bool or number, the text is kept for string flags.
+type yamlValue struct {
+	val  interface{}
+	text string
+}
+
+func (v *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var m map[interface{}]yamlValue
+	if unmarshal(&m) == nil {
+		v.val = m
+		return nil
+	}
+	var l []yamlValue
+	if unmarshal(&l) == nil {
+		v.val = l
+		return nil
+	}
+	err := unmarshal(&v.val)
+	if err != nil {
+		return err
+	}
+	return unmarshal(&v.text)
+}
+
+// yamlScalar is the bool or number scalar of yaml with it's original text.
+type yamlScalar struct {
+	val  interface{}
+	text string
+}
+
+// normalizeYAML convert yaml mappings to map[string]interface{} and integers to int64 to
+// keep consistent with other formats, bool and number scalars are kept with their text.
+func normalizeYAML(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[interface{}]yamlValue:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			m[fmt.Sprint(key)] = normalizeYAML(val)
+		}
+		return m
+	case []yamlValue:
+		l := make([]interface{}, len(v))
+		for i := range v {
+			l[i] = normalizeYAML(v[i])
+		}
+		return l
+	case yamlValue:
+		switch s := v.val.(type) {
+		case bool, float64:
+			return yamlScalar{val: s, text: v.text}
+		case int:
+			return yamlScalar{val: int64(s), text: v.text}
+		case uint64:
+			return yamlScalar{val: float64(s), text: v.text}
+		default:
+			return normalizeYAML(v.val)
+		}
+	default:
+		return v
+	}
+}
+
+func loadConfig(f *FlagSet, context []string, vals map[string]interface{}, rank int) error {
+	context = append(context, f.self.Names)
+	for key, val := range vals {
+		if sub, ok := val.(map[string]interface{}); ok {
+			index, has := f.subsetIndexes[key]
+			if !has {
+				return newErrorf(errFlagNotFound, "config subset is not found: %v.%s", context, key)
+			}
+			err := loadConfig(&f.subsets[index], context, sub, rank)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		flag := f.searchFlagRef(key)
+		if flag == nil {
+			return newErrorf(errFlagNotFound, "config flag is not found: %v.%s", context, key)
+		}
+		if val == nil {
+			// presence of key implies true for bool flag, others are left unset
+			if FlagKind(flag).IsSlice() || !isBoolPtr(flag.Ptr) {
+				continue
+			}
+			val = true
+		}
+		cvals, err := configValues(flag, val)
+		if err != nil {
+			return newErrorf(errInvalidValue, "invalid config value: %v.%s, %s", context, key, err.Error())
+		}
+		flag.configVals = cvals
+		flag.configRank = rank
+	}
+	return nil
+}
+
+// configValues convert config value to strings, the values are checked by applying to a new pointer.
+func configValues(flag *Flag, val interface{}) ([]string, error) {
+	var vals []interface{}
+	if refval := reflect.ValueOf(val); refval.Kind() == reflect.Slice {
+		if !FlagKind(flag).IsSlice() {
+			return nil, newErrorf(errInvalidValue, "array value for non-slice flag")
+		}
+		for i := 0; i < refval.Len(); i++ {
+			vals = append(vals, refval.Index(i).Interface())
+		}
+	} else {
+		vals = []interface{}{val}
+	}
+
+	var (
+		strs = make([]string, 0, len(vals))
+		elem = FlagKind(flag).Elem()
+		tmp  = *flag
+	)
+	tmp.Ptr = reflect.New(reflect.TypeOf(flag.Ptr).Elem()).Interface()
+	for _, v := range vals {
+		var (
+			s        string
+			mismatch bool
+		)
+		typedOnly := elem == KindBool || (elem.isNumber() && !flag.Rune && !flag.Percent)
+		if ys, ok := v.(yamlScalar); ok {
+			if typedOnly {
+				v = ys.val
+			} else {
+				v = ys.text
+			}
+		}
+		switch v := v.(type) {
+		case string:
+			s, mismatch = v, typedOnly
+		case bool:
+			s, mismatch = strconv.FormatBool(v), elem != KindBool
+		case float64:
+			s, mismatch = strconv.FormatFloat(v, 'f', -1, 64), !elem.isNumber()
+		case int64:
+			s, mismatch = strconv.FormatInt(v, 10), !elem.isNumber()
+		case time.Time:
+			s, mismatch = formatValue(flag, v), elem != KindTime
+		default:
+			return nil, newErrorf(errInvalidValue, "unsupported value: %v", v)
+		}
+		if mismatch {
+			return nil, newErrorf(errInvalidValue, "value %v is not %s", v, elem)
+		}
+		err := applyValToPtr(&tmp, s)
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
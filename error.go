@@ -24,6 +24,9 @@ const (
 	errInvalidDefault
 	errInvalidStructure
 	errPositionalFlagNotProvided
+	errFlagDependency
+	errFlagConflict
+	errUnknownCommand
 )
 
 func (t errorType) String() string {
@@ -31,7 +34,7 @@ func (t errorType) String() string {
 	case 0:
 		return "NoError"
 	case errNonPointer:
-		return "NonPointerStructure "
+		return "NonPointerStructure"
 	case errFlagNotFound:
 		return "FlagNotFound"
 	case errInvalidNames:
@@ -50,15 +53,33 @@ func (t errorType) String() string {
 		return "DuplicateFlagParsed"
 	case errInvalidSelects:
 		return "InvalidSelects"
+	case errInvalidDefault:
+		return "InvalidDefault"
 	case errInvalidStructure:
 		return "InvalidStructure"
 	case errPositionalFlagNotProvided:
 		return "PositionalFlagNotProvided"
+	case errFlagDependency:
+		return "FlagDependency"
+	case errFlagConflict:
+		return "FlagConflict"
+	case errUnknownCommand:
+		return "UnknownCommand"
 	default:
 		return "UnknownError"
 	}
 }
 
+// parseErrorType return the error type by it's name returned by String.
+func parseErrorType(name string) (errorType, bool) {
+	for t := errNonPointer; t <= errUnknownCommand; t++ {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
 func (e flagError) Error() string {
 	return e.Value
 }
@@ -2,10 +2,20 @@ package flag
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"text/tabwriter"
+	"text/template"
+	"time"
 )
 
 // Flag represents the state of a flag
@@ -19,16 +29,102 @@ type Flag struct {
 	Ptr       interface{} // value pointer
 
 	// For Flag
-	Default interface{} // default value
-	Selects interface{} // select value
-	Env     string      // environment name
-	ValSep  string      // environment value separator
+	Default     interface{}        // default value
+	DefaultFunc func() interface{} // compute default value on parsing if Default is nil, the result type must be compatible with flag
+	Selects     interface{}        // select value
+	DenySelects interface{}        // forbidden values, the opposite of Selects
+	SelectsCI   bool               // case-insensitive string selects, matched value will be normalized to the select
+	Env         string             // environment name
+	ValSep      string             // environment value separator
+	Layout      string             // time layout, default is time.RFC3339
+	Raw         bool               // store raw bytes of value to []byte pointer, for FlagSet, arguments after it are stored to args pointer verbatim
+	AttachOnly  bool               // value must be attached by '=', the next argument will not be consumed
+	Split       bool               // split command line value of slice flag by ValSep
+	Nargs       int                // count of values consumed by each occurrence of slice flag, 0 means 1
+	Required    bool               // value must be provided by command line, environment or config file
+	Clearable   bool               // explicit empty value clears the slice instead of appending empty element
+	BoolValue   bool               // bool flag could take next argument if it's 'true' or 'false'
+	EnvOnly     bool               // value could only come from environment or default, command line is refused
+	Secret      bool               // value is masked in help message, DumpValues and MarshalValues
+	Rune        bool               // int32 flag takes a single character as value
+	Percent     bool               // float flag takes percentage such as '50%' as ratio 0.5
+	Invert      bool               // bool flag stores the inverted value, e.g. '--disable-cache' sets pointer to false
+	Min         interface{}        // lower bound of numeric flag value, number or string, it's inclusive
+	Max         interface{}        // upper bound of numeric flag value, number or string, it's inclusive
+	source      valueSource        // where the value comes from in last parsing
+	configVals  []string           // values loaded from config file
+	configRank  int                // precedence of the config file which configVals comes from
+	tolerance   float64            // tolerance of float selects comparison
 
 	// For FlagSet
-	Version      string    // version, can be multiple lines
-	versionLines []string  // parsed version lines
-	ArgsPtr      *[]string // non-flag arguments pointer
-	ArgsAnywhere bool      // non-flag args must appears at anywhere, otherwise, it must appears at command line last.
+	Version      string      // version, can be multiple lines
+	versionLines []string    // parsed version lines
+	ArgsPtr      *[]string   // non-flag arguments pointer
+	ArgsTypedPtr interface{} // non-flag arguments pointer of slice of other supported types such as *[]int, each argument is converted, it's ignored if ArgsPtr is set
+	ArgsAnywhere bool        // non-flag args must appears at anywhere, otherwise, it must appears at command line last.
+	ArgsEnv      string      // environment name of non-flag arguments, splitted by ValSep, used if no non-flag argument is provided
+	ArgsDefault  []string    // default non-flag arguments, used if no non-flag argument and environment value is provided
+}
+
+// argsPtr return the pointer of non-flag arguments, ArgsPtr takes precedence over ArgsTypedPtr.
+func (f *Flag) argsPtr() interface{} {
+	if f.ArgsPtr != nil {
+		return f.ArgsPtr
+	}
+	return f.ArgsTypedPtr
+}
+
+// IsRequired report whether flag value must be provided by command line, environment or config file.
+func (f *Flag) IsRequired() bool {
+	return f.Required
+}
+
+// HasDefault report whether flag has default value or default function.
+func (f *Flag) HasDefault() bool {
+	return f.Default != nil || f.DefaultFunc != nil
+}
+
+// DefaultValue return the default value, it's nil if not defined, DefaultFunc is not called.
+func (f *Flag) DefaultValue() interface{} {
+	return f.Default
+}
+
+// AllowedValues return the selects of flag formatted as strings, it's nil if there is no selects.
+func (f *Flag) AllowedValues() []string {
+	if f.Selects == nil {
+		return nil
+	}
+	refval := reflect.ValueOf(f.Selects)
+	vals := make([]string, 0, refval.Len())
+	for i := 0; i < refval.Len(); i++ {
+		vals = append(vals, fmt.Sprint(refval.Index(i).Interface()))
+	}
+	return vals
+}
+
+type valueSource uint8
+
+const (
+	sourceNone valueSource = iota
+	sourceCommandLine
+	sourceEnv
+	sourceDefault
+	sourceConfig
+)
+
+func (s valueSource) String() string {
+	switch s {
+	case sourceCommandLine:
+		return "command line"
+	case sourceEnv:
+		return "environment"
+	case sourceDefault:
+		return "default"
+	case sourceConfig:
+		return "config"
+	default:
+		return "unset"
+	}
 }
 
 // Metadata can be implemented by structure to update flag metadata.
@@ -44,6 +140,14 @@ type NoFlag interface {
 	NoFlag()
 }
 
+// Finalizer can be implemented by structure to do some works after parsing, such as computing
+// derived fields. It's called after successful resolution in parent-to-child order, structures of
+// subsets which are not enabled are skipped.
+type Finalizer interface {
+	// Finalize is called after flags are parsed and applied.
+	Finalize() error
+}
+
 // ErrorHandling is the error handling way when error occurred when register/scan/resolve.
 //
 // ErrorHandling can be set of basic handling way, the way sequence is ErrPanic, ErrPrint, ErrExit.
@@ -61,11 +165,19 @@ const (
 	DefaultErrorHandling = ErrPrint | ErrExit
 )
 
+const defaultExitCode = 2
+
 func (e ErrorHandling) do(eh ErrorHandling) bool {
 	return e&eh != 0
 }
 
 func (e ErrorHandling) handle(err error) error {
+	return e.handleWithUsage(err, "", defaultExitCode)
+}
+
+// handleWithUsage handle error like handle, the usage line is printed after error message if it's not empty,
+// and the process exits with the code if ErrExit is set.
+func (e ErrorHandling) handleWithUsage(err error, usage string, code int) error {
 	if err == nil {
 		return nil
 	}
@@ -75,13 +187,31 @@ func (e ErrorHandling) handle(err error) error {
 	}
 	if e.do(ErrPrint) {
 		fmt.Fprintln(os.Stderr, err)
+		if usage != "" {
+			fmt.Fprintln(os.Stderr, usage)
+		}
 	}
 	if e.do(ErrExit) {
-		os.Exit(2)
+		os.Exit(code)
 	}
 	return err
 }
 
+// NamingStyle is the style of flag and command names generated from field names if they are not
+// specified by the names tag.
+type NamingStyle uint8
+
+const (
+	// NamingDefault lowercase the leading upper case letters, e.g. 'MaxConns' to 'maxConns', 'HTTPAddr' to 'httpaddr'
+	NamingDefault NamingStyle = iota
+	// NamingCamel use lower camel case, e.g. 'MaxConns' to 'maxConns', 'HTTPAddr' to 'httpAddr'
+	NamingCamel
+	// NamingKebab use lower case words joined by '-', e.g. 'MaxConns' to 'max-conns'
+	NamingKebab
+	// NamingSnake use lower case words joined by '_', e.g. 'MaxConns' to 'max_conns'
+	NamingSnake
+)
+
 // FlagSet is a set of flags and other subsets.
 type FlagSet struct {
 	self Flag
@@ -93,8 +223,48 @@ type FlagSet struct {
 	subsetIndexes map[string]int
 
 	errorHandling   ErrorHandling
+	exitCodes       map[errorType]int // exit codes of parse errors keyed by error type
 	noHelpFlag      bool
+	noVerboseFlag   bool
+	noVersionFlag   bool
+	noHelpExit      bool
 	helpFlagDefined bool
+	help            helpFlagValues
+
+	usageLine    func(*FlagSet) string
+	helpTemplate *template.Template
+	helpWidth    int
+	helpLabels   HelpLabels
+
+	activeSubcommand []string
+
+	strictTags  bool
+	ignoredTags []string
+
+	expandEnv         bool
+	noBundling        bool
+	printUsageOnError bool
+	suggestCommands   bool
+	suggestFlags      bool
+	allowExtraArgs    bool
+	floatTolerance    float64
+	defaultValSep     string
+	ignoreUnknown     bool
+	namingStyle       NamingStyle
+	globalFlags       bool
+	unknownFlags      []string
+	argsStart         int
+	unknownArgs       []string
+	expandArgFiles    bool
+
+	finalizer  Finalizer
+	parentPath []string // names of ancestors from root
+	groups     []flagGroup
+	bound      boundPtrs // pointers of structures and args bound to the flagset tree, shared by subsets
+
+	// for repeatable subset, pointer of slice collecting occurrences and the scratch element bound to flags
+	repeatSlice interface{}
+	repeatElem  interface{}
 }
 
 // NewFlagSet create a new flagset
@@ -107,12 +277,18 @@ func NewFlagSet(flag Flag) *FlagSet {
 
 func newFlagSet(flag Flag) *FlagSet {
 	defaultRegister.cleanFlag(&flag)
-	return &FlagSet{
+	f := &FlagSet{
 		self:          flag,
 		flagIndexes:   make(map[string]int),
 		subsetIndexes: make(map[string]int),
 		errorHandling: DefaultErrorHandling,
+		bound:         make(boundPtrs),
+		argsStart:     -1,
+	}
+	if ptr := flag.argsPtr(); ptr != nil {
+		f.bound.bind(ptr, flag.Names)
 	}
+	return f
 }
 
 func (f *FlagSet) searchFlag(name string) *Flag {
@@ -123,6 +299,26 @@ func (f *FlagSet) searchFlag(name string) *Flag {
 	return &f.flags[index]
 }
 
+// searchFlagRef search flag referenced by ${name}, name may omit the leading dashes.
+func (f *FlagSet) searchFlagRef(name string) *Flag {
+	for _, prefix := range []string{"", "--", "-"} {
+		if flag := f.searchFlag(prefix + name); flag != nil {
+			return flag
+		}
+	}
+	return nil
+}
+
+// commandName return the first name of flagset, it's used to report stable command path whatever
+// alias user typed.
+func (f *FlagSet) commandName() string {
+	names, _ := defaultRegister.cleanFlagNames(f.self.Names)
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
 func (f *FlagSet) isFlag(name string) bool {
 	_, has := f.flagIndexes[name]
 	return has
@@ -137,6 +333,10 @@ func (f *FlagSet) isFlagOrSubset(name string) bool {
 	return f.isFlag(name) || f.isSubset(name)
 }
 
+func (f *FlagSet) acceptNonFlag() bool {
+	return f.self.argsPtr() != nil || f.isFlag(flagNamePositional)
+}
+
 // UpdateMeta update flag metadata by the children identifier, only Desc, Arglist,
 // Usage and Version will be updated.
 // The children identifier will be split by ',', if children is empty, it update
@@ -147,16 +347,23 @@ func (f *FlagSet) UpdateMeta(children string, meta Flag) error {
 	return defaultRegister.updateMeta(f, children, meta)
 }
 
-// ErrHandling change the way of error handling
+// ErrHandling change the way of error handling, it's recursive, all subsets are overwritten.
 func (f *FlagSet) ErrHandling(ehs ...ErrorHandling) *FlagSet {
+	f.SetErrHandling(ehs...)
+	for i := range f.subsets {
+		f.subsets[i].ErrHandling(f.errorHandling)
+	}
+	return f
+}
+
+// SetErrHandling change the way of error handling of current flagset only, subsets are not affected.
+// Parse errors are handled by the flagset where error occurred.
+func (f *FlagSet) SetErrHandling(ehs ...ErrorHandling) *FlagSet {
 	var e ErrorHandling
 	for _, eh := range ehs {
 		e |= eh
 	}
 	f.errorHandling = e
-	for i := range f.subsets {
-		f.subsets[i].ErrHandling(f.errorHandling)
-	}
 	return f
 }
 
@@ -170,11 +377,259 @@ func (f *FlagSet) NeedHelpFlag(need bool) *FlagSet {
 	return f
 }
 
+// NeedVerboseFlag toggle verbose flag auto-defining. By default, if the help flag is auto-defined
+// and there are subsets, the verbose flag will also be defined when Parse is called.
+func (f *FlagSet) NeedVerboseFlag(need bool) *FlagSet {
+	f.noVerboseFlag = !need
+	for i := range f.subsets {
+		f.subsets[i].NeedVerboseFlag(need)
+	}
+	return f
+}
+
+// NeedVersionFlag toggle version flag auto-defining. By default, if the flagset has version message,
+// the '--version' flag will be defined when Parse is called.
+func (f *FlagSet) NeedVersionFlag(need bool) *FlagSet {
+	f.noVersionFlag = !need
+	for i := range f.subsets {
+		f.subsets[i].NeedVersionFlag(need)
+	}
+	return f
+}
+
+// SetUsageLine set the function to compute the argument list displayed in the usage line of help message,
+// it overrides both the Arglist field and the automatically computed one.
+func (f *FlagSet) SetUsageLine(fn func(*FlagSet) string) *FlagSet {
+	f.usageLine = fn
+	return f
+}
+
+// StrictTags toggle strict tag checking, if enabled, StructFlags will report error for unknown
+// tag keys of structure fields, keys used by other libraries such as json, yaml should be
+// passed as ignoredKeys.
+func (f *FlagSet) StrictTags(strict bool, ignoredKeys ...string) *FlagSet {
+	f.strictTags = strict
+	f.ignoredTags = ignoredKeys
+	return f
+}
+
+// ExpandEnv toggle environment variables expanding of string and string slice values,
+// $NAME and ${NAME} are replaced by environment values, literal '$' could be written as '$$'.
+// It applies to values from command line, environment and default, it's recursive for subsets.
+func (f *FlagSet) ExpandEnv(expand bool) *FlagSet {
+	f.expandEnv = expand
+	for i := range f.subsets {
+		f.subsets[i].ExpandEnv(expand)
+	}
+	return f
+}
+
+// ExpandArgFiles toggle expanding of argument files, when enabled, each argument like '@file'
+// is replaced by the whitespace-splitted arguments read from the file before parsing. Argument files
+// could contain '@file' too, and cyclic reference is an error. It only affects the flagset calling Parse.
+func (f *FlagSet) ExpandArgFiles(expand bool) *FlagSet {
+	f.expandArgFiles = expand
+	return f
+}
+
+// PrintUsageOnError toggle printing the brief usage line after error message when parsing failed
+// and ErrPrint is enabled, it's disabled by default and it's recursive for subsets.
+func (f *FlagSet) PrintUsageOnError(print bool) *FlagSet {
+	f.printUsageOnError = print
+	for i := range f.subsets {
+		f.subsets[i].PrintUsageOnError(print)
+	}
+	return f
+}
+
+// SuggestCommands toggle unknown command reporting, if enabled, the first non-flag argument of command
+// which has subcommands must be a subcommand, otherwise an error with the most similar subcommand
+// name as suggestion is reported. It's recursive for subsets.
+func (f *FlagSet) SuggestCommands(suggest bool) *FlagSet {
+	f.suggestCommands = suggest
+	for i := range f.subsets {
+		f.subsets[i].SuggestCommands(suggest)
+	}
+	return f
+}
+
+// SuggestFlags toggle suggestion of the most similar flag name for unknown flag error.
+// It's recursive for subsets.
+func (f *FlagSet) SuggestFlags(suggest bool) *FlagSet {
+	f.suggestFlags = suggest
+	for i := range f.subsets {
+		f.subsets[i].SuggestFlags(suggest)
+	}
+	return f
+}
+
+// NamingStyle set the style of names generated from field names for flags and subsets without names
+// tag, it should be called before StructFlags. Flag names generated by styles other than NamingDefault
+// use '--' prefix if they are longer than one character. It's recursive for subsets.
+func (f *FlagSet) NamingStyle(style NamingStyle) *FlagSet {
+	f.namingStyle = style
+	for i := range f.subsets {
+		f.subsets[i].NamingStyle(style)
+	}
+	return f
+}
+
+// GlobalFlags toggle whether flags of the flagset could also be used after it's subcommands, e.g.
+// 'app run -v' sets '-v' of app and stays in 'run', otherwise it leaves 'run' and following arguments
+// belong to app. Global flag is parsed as if it's typed before the subcommand, so it's duplicated if
+// it's provided both before and after the subcommand, values of slice flag are appended in order of
+// the sections. Short flags bundled in a cluster are not searched as global flags. It's recursive
+// for subsets.
+func (f *FlagSet) GlobalFlags(global bool) *FlagSet {
+	f.globalFlags = global
+	for i := range f.subsets {
+		f.subsets[i].GlobalFlags(global)
+	}
+	return f
+}
+
+// IgnoreUnknown toggle collecting unknown flags and non-flag values not accepted by command instead of
+// reporting error, they could be retrieved by Unknown after parsing. It's recursive for subsets.
+func (f *FlagSet) IgnoreUnknown(ignore bool) *FlagSet {
+	f.ignoreUnknown = ignore
+	for i := range f.subsets {
+		f.subsets[i].IgnoreUnknown(ignore)
+	}
+	return f
+}
+
+// Unknown return the unknown flags and non-flag values collected in last parsing if IgnoreUnknown is
+// enabled, flags are kept as typed in command line such as '--name=value', value of unknown flag
+// which is not attached by '=' is collected to args since it's indistinguishable from non-flag value.
+func (f *FlagSet) Unknown() (flags, args []string) {
+	return f.unknownFlags, f.unknownArgs
+}
+
+// ArgsStart return index of the first non-flag value consumed by positional flags or args field in
+// the arguments of last parsing, arguments from it are the trailing values of command line, e.g.
+// os.Args[set.ArgsStart():]. The index is counted after expanding argument files, -1 is returned
+// if there is no such value or the flagset is not parsed. It's only recorded for the parsed flagset.
+func (f *FlagSet) ArgsStart() int {
+	return f.argsStart
+}
+
+// DefaultValSep set the value separator of flags and non-flag arguments which doesn't specify it,
+// the default is ','. It only affects flags registered later, so it should be called before
+// registering. It's recursive for subsets.
+func (f *FlagSet) DefaultValSep(sep string) *FlagSet {
+	f.defaultValSep = sep
+	if f.self.argsPtr() == nil {
+		f.self.ValSep = f.valSep()
+	}
+	for i := range f.subsets {
+		f.subsets[i].DefaultValSep(sep)
+	}
+	return f
+}
+
+func (f *FlagSet) valSep() string {
+	if f.defaultValSep != "" {
+		return f.defaultValSep
+	}
+	return ","
+}
+
+// FloatTolerance set the tolerance of comparing float value with selects, value is valid if the
+// difference is not greater than eps, integer selects are always compared exactly. It's recursive
+// for subsets.
+func (f *FlagSet) FloatTolerance(eps float64) *FlagSet {
+	f.floatTolerance = eps
+	for i := range f.flags {
+		f.flags[i].tolerance = eps
+	}
+	for i := range f.subsets {
+		f.subsets[i].FloatTolerance(eps)
+	}
+	return f
+}
+
+// ExitCodeFor set the exit code used by ErrExit when parsing failed with the error type, the type is
+// the category name of error such as "FlagNotFound", "InvalidValue", "FlagValueNotProvided", other
+// errors exit with code 2. Unknown type names are rejected. It's recursive for subsets.
+func (f *FlagSet) ExitCodeFor(errType string, code int) error {
+	t, has := parseErrorType(errType)
+	if !has {
+		return f.errorHandling.handle(newErrorf(errInvalidValue, "unknown error type: %s", errType))
+	}
+	f.setExitCode(t, code)
+	return nil
+}
+
+func (f *FlagSet) setExitCode(t errorType, code int) {
+	if f.exitCodes == nil {
+		f.exitCodes = make(map[errorType]int)
+	}
+	f.exitCodes[t] = code
+	for i := range f.subsets {
+		f.subsets[i].setExitCode(t, code)
+	}
+}
+
+// AllowExtraArgs toggle ignoring non-flag values if command has no args field and positional flags
+// to accept them, otherwise they are reported as error. It's recursive for subsets.
+func (f *FlagSet) AllowExtraArgs(allow bool) *FlagSet {
+	f.allowExtraArgs = allow
+	for i := range f.subsets {
+		f.subsets[i].AllowExtraArgs(allow)
+	}
+	return f
+}
+
+// Bundling toggle short flag bundling, it's enabled by default. If disabled, '-abc' is always
+// treated as a single flag named '-abc' instead of '-a -b -c' or '-a bc'. It should be called
+// before flags registering, and it's recursive for subsets.
+func (f *FlagSet) Bundling(enable bool) *FlagSet {
+	f.noBundling = !enable
+	for i := range f.subsets {
+		f.subsets[i].Bundling(enable)
+	}
+	return f
+}
+
 // Flag add a flag to current flagset, it should not duplicate with parent/current/children levels' flag or flagset.
 func (f *FlagSet) Flag(flag Flag) error {
 	return f.errorHandling.handle(defaultRegister.registerFlag(nil, f, flag))
 }
 
+func (f *FlagSet) varFlag(ptr interface{}, names, usage string) error {
+	return f.Flag(Flag{Names: names, Usage: usage, Ptr: ptr})
+}
+
+// IntVar add an int flag with names and usage, value will be stored to p.
+func (f *FlagSet) IntVar(p *int, names, usage string) error {
+	return f.varFlag(p, names, usage)
+}
+
+// IntsVar add an int slice flag with names and usage, value will be stored to p.
+func (f *FlagSet) IntsVar(p *[]int, names, usage string) error {
+	return f.varFlag(p, names, usage)
+}
+
+// StringVar add a string flag with names and usage, value will be stored to p.
+func (f *FlagSet) StringVar(p *string, names, usage string) error {
+	return f.varFlag(p, names, usage)
+}
+
+// StringsVar add a string slice flag with names and usage, value will be stored to p.
+func (f *FlagSet) StringsVar(p *[]string, names, usage string) error {
+	return f.varFlag(p, names, usage)
+}
+
+// BoolVar add a bool flag with names and usage, value will be stored to p.
+func (f *FlagSet) BoolVar(p *bool, names, usage string) error {
+	return f.varFlag(p, names, usage)
+}
+
+// DurationVar add a time.Duration flag with names and usage, value will be stored to p.
+func (f *FlagSet) DurationVar(p *time.Duration, names, usage string) error {
+	return f.varFlag(p, names, usage)
+}
+
 // Subset add a flagset to current flagset and return the subset
 func (f *FlagSet) Subset(flag Flag) (*FlagSet, error) {
 	child, err := defaultRegister.registerSet(nil, f, flag)
@@ -210,43 +665,351 @@ func (f *FlagSet) StructFlags(val interface{}, parent ...*FlagSet) error {
 }
 
 type helpFlagValues struct {
-	showHelp bool
+	showHelp     bool
+	children     string // subcommand path attached to help flag, e.g. 'remote.add'
+	verboseLevel int
+	showVersion  bool
 }
 
 func registerHelpFlags(r register, parent, set *FlagSet, flags *helpFlagValues) error {
-	_, err := r.registerFlagsIfNotDuplicated(parent, set, []string{"-h", "--help"}, &flags.showHelp, "show help")
+	added, err := r.registerFlagsIfNotDuplicated(parent, set, []string{"-h", "--help"}, &flags.showHelp, "show help")
+	if err != nil || !added || set.noVerboseFlag || len(set.subsets) == 0 {
+		return err
+	}
+	_, err = r.registerFlagsIfNotDuplicated(parent, set, []string{"-v", "--verbose"}, &flags.verboseLevel, "show verbose help, expand level of child command, -1 means all")
+	return err
+}
+
+func registerVersionFlag(r register, parent, set *FlagSet, flags *helpFlagValues) error {
+	_, err := r.registerFlagsIfNotDuplicated(parent, set, []string{"--version"}, &flags.showVersion, "show version")
 	return err
 }
 
+var (
+	// ErrHelp is returned by Parse when help flag is passed and ExitOnHelp is disabled.
+	ErrHelp = errors.New("flag: help requested")
+	// ErrVersion is returned by Parse when version flag is passed and ExitOnHelp is disabled.
+	ErrVersion = errors.New("flag: version requested")
+)
+
+// ExitOnHelp toggle process exiting after help or version message is printed. By default,
+// Parse exit the process with code 0, if disabled, Parse return ErrHelp or ErrVersion instead.
+func (f *FlagSet) ExitOnHelp(exit bool) *FlagSet {
+	f.noHelpExit = !exit
+	return f
+}
+
+// HelpRequested report whether help flag is passed in last Parse.
+func (f *FlagSet) HelpRequested() bool {
+	return f.help.showHelp
+}
+
+// Scan scan arguments without resolving, if empty, os.Args will be used. The result could be
+// used to build custom dispatching or resolution strategies, flag values are not applied.
+// Scan does no validation, unknown flags and values are kept as tokens, they are only reported
+// by Parse.
+func (f *FlagSet) Scan(args []string) *ScanResult {
+	if len(args) == 0 {
+		args = os.Args
+	}
+	var s scanner
+	s.scan(f, args)
+	return newScanResult(&s.Result)
+}
+
 // Parse parse arguments, if empty, os.Args will be used.
 func (f *FlagSet) Parse(args ...string) error {
 	if len(args) == 0 {
 		args = os.Args
 	}
-	var help helpFlagValues
+	return f.parse(context.Background(), args, defaultRanks)
+}
+
+// ParseReader parse arguments read from reader, the content is splitted to arguments by whitespaces
+// and newlines like shell, quotes and backslash escaping are supported, e.g. `--name "a b"`. Command
+// name should not be included, it's useful for REPL.
+func (f *FlagSet) ParseReader(r io.Reader) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return f.errorHandling.handle(newErrorf(errInvalidValue, "read arguments failed: %s", err.Error()))
+	}
+	args, err := splitArgs(string(content))
+	if err != nil {
+		return f.errorHandling.handle(err)
+	}
+	return f.parse(context.Background(), append([]string{f.self.Names}, args...), defaultRanks)
+}
+
+// ParseContext is like Parse, the context is checked between resolving of flags, if it's done,
+// parsing is aborted with the context error. It's useful when default functions are slow.
+func (f *FlagSet) ParseContext(ctx context.Context, args ...string) error {
+	if len(args) == 0 {
+		args = os.Args
+	}
+	return f.parse(ctx, args, defaultRanks)
+}
+
+func (f *FlagSet) parse(ctx context.Context, args []string, ranks sourceRanks) error {
+	if f.expandArgFiles {
+		var err error
+		args, err = expandArgFiles(args, nil)
+		if err != nil {
+			return f.errorHandling.handle(err)
+		}
+	}
+	f.help = helpFlagValues{}
+	f.resetSources()
 	if !f.noHelpFlag && !f.helpFlagDefined {
-		err := registerHelpFlags(defaultRegister, nil, f, &help)
+		err := registerHelpFlags(defaultRegister, nil, f, &f.help)
+		if err != nil {
+			return f.errorHandling.handle(err)
+		}
+	}
+	if !f.noVersionFlag && len(f.self.versionLines) > 0 {
+		err := registerVersionFlag(defaultRegister, nil, f, &f.help)
 		if err != nil {
 			return f.errorHandling.handle(err)
 		}
 	}
 	var (
 		s scanner
-		r resolver
+		r = resolver{ranks: ranks, ctx: ctx, argsStart: -1}
 	)
 	s.scan(f, args)
 	err := r.resolve(f, &s.Result)
+	f.unknownFlags, f.unknownArgs, f.argsStart = r.unknownFlags, r.unknownArgs, r.argsStart
 	if err != nil {
-		return f.errorHandling.handle(err)
+		if r.ErrSet != nil {
+			return r.ErrSet.handleParseError(err)
+		}
+		return f.handleParseError(err)
 	}
+	f.activeSubcommand = r.LastPath
 
-	if help.showHelp {
-		r.LastSet.Help()
+	if f.help.showHelp {
+		// help is scoped to the command where help flag is typed, 'tool -h sub' shows help of tool
+		helpSet := r.LastSet
+		if s.HelpStack != nil {
+			helpSet = s.stackTopFlagSet(f, s.HelpStack)
+		}
+		if f.help.children != "" {
+			children := strings.Replace(f.help.children, ".", flagNameSeparatorForSplit, -1)
+			if err := helpSet.HelpFor(children, f.help.verboseLevel); err != nil {
+				return helpSet.handleParseError(err)
+			}
+		} else {
+			fmt.Print(helpSet.ToString(f.help.verboseLevel))
+		}
+		if f.noHelpExit {
+			return ErrHelp
+		}
+		os.Exit(0)
+	}
+	if f.help.showVersion {
+		for _, line := range f.self.versionLines {
+			fmt.Println(line)
+		}
+		if f.noHelpExit {
+			return ErrVersion
+		}
 		os.Exit(0)
 	}
+	if set, err := f.finalize(); err != nil {
+		return set.handleParseError(err)
+	}
 	return nil
 }
 
+func (f *FlagSet) handleParseError(err error) error {
+	var usage string
+	if f.printUsageOnError {
+		usage = f.UsageLine()
+	}
+	return f.errorHandling.handleWithUsage(err, usage, f.exitCode(err))
+}
+
+func (f *FlagSet) exitCode(err error) int {
+	if e, ok := err.(flagError); ok {
+		if code, has := f.exitCodes[e.Type]; has {
+			return code
+		}
+	}
+	return defaultExitCode
+}
+
+func (f *FlagSet) finalize() (*FlagSet, error) {
+	if f.finalizer != nil {
+		err := f.finalizer.Finalize()
+		if err != nil {
+			return f, err
+		}
+	}
+	for i := range f.subsets {
+		set := &f.subsets[i]
+		if enable, ok := set.self.Ptr.(*bool); !ok || !*enable {
+			continue
+		}
+		if set, err := set.finalize(); err != nil {
+			return set, err
+		}
+	}
+	return nil, nil
+}
+
+// VisitAll call fn for each flag of the flagset and it's subsets recursively, path is the
+// command names from root to the flagset which the flag belongs to.
+func (f *FlagSet) VisitAll(fn func(path []string, flag *Flag)) {
+	f.visitAll(nil, fn)
+}
+
+func (f *FlagSet) visitAll(path []string, fn func(path []string, flag *Flag)) {
+	path = append(path[:len(path):len(path)], f.self.Names)
+	for i := range f.flags {
+		fn(path, &f.flags[i])
+	}
+	for i := range f.subsets {
+		f.subsets[i].visitAll(path, fn)
+	}
+}
+
+// CommandInfo is the brief information of a command in the command tree.
+type CommandInfo struct {
+	Path  []string // command names from root, the first name of each command is used
+	Usage string   // short usage message
+	Flags []string // flag names, each is comma-separated names of a flag
+}
+
+// Commands return information of the flagset and it's subsets recursively in declaration order,
+// the flagset itself is the first one.
+func (f *FlagSet) Commands() []CommandInfo {
+	return f.commands(nil, nil)
+}
+
+func (f *FlagSet) commands(path []string, infos []CommandInfo) []CommandInfo {
+	names, _ := defaultRegister.cleanFlagNames(f.self.Names)
+	path = append(path[:len(path):len(path)], names[0])
+
+	info := CommandInfo{
+		Path:  path,
+		Usage: f.self.Usage,
+		Flags: make([]string, 0, len(f.flags)),
+	}
+	for i := range f.flags {
+		info.Flags = append(info.Flags, f.flags[i].Names)
+	}
+	infos = append(infos, info)
+	for i := range f.subsets {
+		infos = f.subsets[i].commands(path, infos)
+	}
+	return infos
+}
+
+// DumpValues write current value and it's source of each flag as 'name=value (source)' lines,
+// it's useful to debug precedence between command line, environment and default value.
+func (f *FlagSet) DumpValues(w io.Writer) error {
+	var err error
+	f.VisitAll(func(path []string, flag *Flag) {
+		if err != nil {
+			return
+		}
+		names := strings.Join(append(path[1:len(path):len(path)], flag.Names), ".")
+		val := formatPtrValue(flag)
+		if flag.Secret {
+			val = secretMask
+		}
+		_, err = fmt.Fprintf(w, "%s=%s (%s)\n", names, val, flag.source)
+	})
+	return err
+}
+
+// MarshalValues return current values of flags as json object, subsets are nested objects keyed by
+// their first name, flags are keyed by the first long name without leading dashes and nil optional
+// flags are omitted. Values of time, duration, ip and url are formatted as strings like help message.
+func (f *FlagSet) MarshalValues() ([]byte, error) {
+	return json.Marshal(f.valuesMap(f))
+}
+
+func (f *FlagSet) valuesMap(root *FlagSet) map[string]interface{} {
+	vals := make(map[string]interface{})
+	for i := range f.flags {
+		flag := &f.flags[i]
+		if root.isHelpFlag(flag) {
+			continue
+		}
+		if val, ok := marshalValue(flag); ok {
+			if flag.Secret {
+				val = secretMask
+			}
+			vals[valueKey(flag)] = val
+		}
+	}
+	for i := range f.subsets {
+		set := &f.subsets[i]
+		names, _ := defaultRegister.cleanFlagNames(set.self.Names)
+		vals[names[0]] = set.valuesMap(root)
+	}
+	return vals
+}
+
+func (f *FlagSet) isHelpFlag(flag *Flag) bool {
+	return flag.Ptr == interface{}(&f.help.showHelp) || flag.Ptr == interface{}(&f.help.verboseLevel) ||
+		flag.Ptr == interface{}(&f.help.showVersion)
+}
+
+func valueKey(flag *Flag) string {
+	if flag.Names == flagNamePositional {
+		return flag.Arglist
+	}
+	names, _ := defaultRegister.cleanFlagNames(flag.Names)
+	name := names[0]
+	for _, n := range names {
+		if strings.HasPrefix(n, "--") {
+			name = n
+			break
+		}
+	}
+	return strings.TrimLeft(name, "-")
+}
+
+func marshalValue(flag *Flag) (interface{}, bool) {
+	refval := reflect.ValueOf(flag.Ptr).Elem()
+	if isOptionalPtr(flag.Ptr) {
+		if refval.IsNil() {
+			return nil, false
+		}
+		refval = refval.Elem()
+	}
+	switch {
+	case flag.Invert:
+		return !refval.Bool(), true
+	case flag.Raw:
+		return string(refval.Bytes()), true
+	case !isParsedKind(FlagKind(flag)) && !flag.Rune:
+		return refval.Interface(), true
+	case FlagKind(flag).IsSlice():
+		vals := make([]string, refval.Len())
+		for i := range vals {
+			vals[i] = formatValue(flag, refval.Index(i).Interface())
+		}
+		return vals, true
+	default:
+		return formatValue(flag, refval.Interface()), true
+	}
+}
+
+// ActiveSubcommand return names of the last resolved subcommand path after Parse, from root to the
+// deepest subset, e.g. ["tool", "remote", "add"]. Each command is reported by it's first name
+// even if an alias is typed.
+func (f *FlagSet) ActiveSubcommand() []string {
+	return f.activeSubcommand
+}
+
+// HasSubcommand report whether last Parse resolved any subcommand, it's false if only flags and
+// arguments of root are provided.
+func (f *FlagSet) HasSubcommand() bool {
+	return len(f.activeSubcommand) > 1
+}
+
 // ParseStruct is the combination of StructFlags and Parse
 func (f *FlagSet) ParseStruct(val interface{}, args ...string) error {
 	err := f.StructFlags(val)
@@ -258,25 +1021,141 @@ func (f *FlagSet) ParseStruct(val interface{}, args ...string) error {
 
 // String return help message
 func (f *FlagSet) String() string {
+	return f.ToString(0)
+}
+
+// ToString return help message, verboseLevel is the expand level of child command, -1 means all.
+func (f *FlagSet) ToString(verboseLevel int) string {
 	var buf bytes.Buffer
 	tw := tabwriter.NewWriter(&buf, 0, 0, 4, ' ', 0)
+	if f.helpTemplate != nil {
+		// template is checked when it's set, if it still fails, the builtin help message is used
+		// instead of partial output.
+		var tbuf bytes.Buffer
+		if err := f.helpTemplate.Execute(&tbuf, newHelpData(f, verboseLevel)); err == nil {
+			tw.Write(tbuf.Bytes())
+			tw.Flush()
+			return buf.String()
+		}
+	}
 	(&helpWriter{
-		buf:   tw,
-		isTop: true,
+		buf:          tw,
+		verboseLevel: verboseLevel,
+		width:        f.helpColumns(),
 	}).writeCommand(f)
 	tw.Flush()
 	return buf.String()
 }
 
+// HelpWidth set the columns to wrap flag usages and descriptions of help message, wrapped usage
+// lines are aligned below the first one, lines starting with space and code fences are left
+// unwrapped. If it's 0, the COLUMNS environment variable is used if
+// defined, negative value disables wrapping. It's recursive for subsets.
+func (f *FlagSet) HelpWidth(cols int) *FlagSet {
+	f.helpWidth = cols
+	for i := range f.subsets {
+		f.subsets[i].HelpWidth(cols)
+	}
+	return f
+}
+
+// HelpLabels is the section titles of help message, empty fields use the default English titles.
+type HelpLabels struct {
+	Usage       string // default 'Usage:'
+	Version     string // default 'Version:'
+	Description string // default 'Description:'
+	Flags       string // default 'Flags:'
+	Commands    string // default 'Commands:'
+}
+
+var defaultHelpLabels = HelpLabels{
+	Usage:       "Usage:",
+	Version:     "Version:",
+	Description: "Description:",
+	Flags:       "Flags:",
+	Commands:    "Commands:",
+}
+
+// HelpLabels set the section titles of help message, it's useful to localize help message. It's
+// recursive for subsets.
+func (f *FlagSet) HelpLabels(labels HelpLabels) *FlagSet {
+	f.helpLabels = labels
+	for i := range f.subsets {
+		f.subsets[i].HelpLabels(labels)
+	}
+	return f
+}
+
+func (f *FlagSet) labels() HelpLabels {
+	labels := f.helpLabels
+	for _, l := range []struct {
+		label *string
+		def   string
+	}{
+		{&labels.Usage, defaultHelpLabels.Usage},
+		{&labels.Version, defaultHelpLabels.Version},
+		{&labels.Description, defaultHelpLabels.Description},
+		{&labels.Flags, defaultHelpLabels.Flags},
+		{&labels.Commands, defaultHelpLabels.Commands},
+	} {
+		if *l.label == "" {
+			*l.label = l.def
+		}
+	}
+	return labels
+}
+
+func (f *FlagSet) helpColumns() int {
+	if f.helpWidth != 0 {
+		return f.helpWidth
+	}
+	cols, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+	return cols
+}
+
+// UsageLine return the brief usage line of help message, such as 'Usage: tool [FLAG]... [ARG]...',
+// it's useful to print a brief usage on error instead of the entire help message.
+func (f *FlagSet) UsageLine() string {
+	normal, positional := splitPositionalFlags(f)
+	return usageLine(f, normal, positional)
+}
+
 // Help print help message to stdout
 func (f *FlagSet) Help() {
 	fmt.Print(f.String())
 }
 
+// HelpFor print help message of the subset found by the children identifier to stdout,
+// children is subset names split by ',', verboseLevel is the same as ToString.
+func (f *FlagSet) HelpFor(children string, verboseLevel int) error {
+	flag, subset, err := defaultRegister.searchChildrenFlag(f, children)
+	if err != nil {
+		return err
+	}
+	if flag != &subset.self {
+		return newErrorf(errFlagNotFound, "subset %s is not found", children)
+	}
+	fmt.Print(subset.ToString(verboseLevel))
+	return nil
+}
+
 // Reset reset values of each registered flags.
 func (f *FlagSet) Reset() {
 	var r resolver
 	r.reset(f)
+	f.activeSubcommand = nil
+	f.argsStart = -1
+}
+
+// ResetReapply reset values like Reset, then reapply environment, config file and default values of
+// the flagset as if it's parsed without command line arguments, e.g. to reload environment in daemon.
+// Reset leaves zero values instead. Command line values are not reapplied since they are not stored,
+// and subsets are left reset since they are not enabled.
+func (f *FlagSet) ResetReapply() error {
+	f.Reset()
+	r := resolver{ranks: defaultRanks, expandEnv: f.expandEnv}
+	err := r.applyEnvAndDefault(f, make(map[*Flag]bool))
+	return f.errorHandling.handle(err)
 }
 
 var (
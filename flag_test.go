@@ -1,10 +1,21 @@
 package flag
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"text/template"
+	"time"
 
 	"github.com/cosiner/argv"
 )
@@ -28,6 +39,16 @@ var tarCase = TestCases{
 				File:        "a.tgz",
 			},
 		},
+		{
+			Cmds: []string{
+				"tar -fa.tgz",
+				"tar -f a.tgz",
+				"tar -f=a.tgz",
+			},
+			Value: &Tar{
+				File: "a.tgz",
+			},
+		},
 		{
 			Cmds: []string{
 				"tar -- -file -",
@@ -60,6 +81,17 @@ var tarCase = TestCases{
 				SourceFiles: []string{"-file", "-file2", "-file3", "--file4"},
 			},
 		},
+		{
+			Cmds: []string{
+				"tar -zcf a.tgz",
+				"tar -zcf=a.tgz",
+			},
+			Value: &Tar{
+				GZ:     true,
+				Create: true,
+				File:   "a.tgz",
+			},
+		},
 		{
 			Cmds: []string{
 				"tar -z",
@@ -89,6 +121,8 @@ var tarCase = TestCases{
 				"tar -z=aaa aaa",
 				"tar -z=true bbb -f a.tgz",
 				"tar -z=true -z=true",
+				"tar -fz a.tgz",
+				"tar -zfc a.tgz",
 			},
 			Errors: []errorType{
 				errFlagNotFound,
@@ -97,6 +131,8 @@ var tarCase = TestCases{
 				errInvalidValue,
 				errNonFlagValue,
 				errDuplicateFlagParsed,
+				errFlagValueNotProvided,
+				errFlagValueNotProvided,
 			},
 		},
 	},
@@ -269,6 +305,18 @@ type errorFlags2 struct {
 	}
 }
 
+type errorFlags3 struct {
+	GZ     bool `names:"-z"`
+	Create bool `names:"-c"`
+	Both   bool `names:"-zc"`
+}
+
+type errorFlags4 struct {
+	Both   bool `names:"-zc"`
+	GZ     bool `names:"-z"`
+	Create bool `names:"-c"`
+}
+
 type TypeCase struct {
 	Env    map[string]string
 	Cmds   []string
@@ -300,6 +348,14 @@ func TestFlags(t *testing.T) {
 			Dst:   new(errorFlags2),
 			Error: errDuplicateFlagRegister,
 		},
+		{
+			Dst:   new(errorFlags3),
+			Error: errDuplicateFlagRegister,
+		},
+		{
+			Dst:   new(errorFlags4),
+			Error: errDuplicateFlagRegister,
+		},
 	}
 	var (
 		gotErrorType = func(err error) errorType {
@@ -432,3 +488,4177 @@ func TestPositional(t *testing.T) {
 	}
 	fs.Help()
 }
+
+func TestTime(t *testing.T) {
+	type Flags struct {
+		Since  time.Time   `names:"--since" layout:"2006-01-02"`
+		Until  time.Time   `names:"--until" layout:"2006-01-02" default:"2023-12-31"`
+		Points []time.Time `names:"--point"`
+	}
+
+	var flags Flags
+	err := NewFlagSet(Flag{}).ErrHandling(0).ParseStruct(&flags, "test", "--since", "2023-01-02",
+		"--point", "2023-01-02T15:04:05Z", "--point=2023-01-03T15:04:05Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flags.Since.Equal(time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatal("time test failed", flags.Since)
+	}
+	if !flags.Until.Equal(time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Fatal("time default test failed", flags.Until)
+	}
+	if len(flags.Points) != 2 || !flags.Points[1].Equal(time.Date(2023, 1, 3, 15, 4, 5, 0, time.UTC)) {
+		t.Fatal("time slice test failed", flags.Points)
+	}
+
+	err = NewFlagSet(Flag{}).ErrHandling(0).ParseStruct(new(Flags), "test", "--since", "01/02/2023")
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("time error test failed", err)
+	}
+}
+
+func TestVerboseFlag(t *testing.T) {
+	type Tool struct {
+		Version bool `names:"-v"`
+		Sub     struct {
+			Enable bool
+		}
+	}
+	var (
+		tool Tool
+		cmd  GoCmd
+	)
+
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.ParseStruct(&cmd, "go"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set.FindFlag("--verbose"); err != nil {
+		t.Fatal("verbose flag should be defined", err)
+	}
+
+	set = NewFlagSet(Flag{}).ErrHandling(0).NeedVerboseFlag(false)
+	if err := set.ParseStruct(&tool, "tool", "-v"); err != nil {
+		t.Fatal(err)
+	}
+	if !tool.Version {
+		t.Fatal("user defined -v flag should be applied")
+	}
+	if _, err := set.FindFlag("--verbose"); err == nil {
+		t.Fatal("verbose flag should not be defined")
+	}
+	if _, err := set.FindFlag("--help"); err != nil {
+		t.Fatal("help flag should be defined", err)
+	}
+}
+
+func TestVerboseHelp(t *testing.T) {
+	type Git struct {
+		Quiet  bool `names:"-q" usage:"quiet mode"`
+		Remote struct {
+			Enable  bool
+			Verbose bool `names:"--debug" usage:"be verbose"`
+			Add     struct {
+				Enable bool
+				Fetch  bool `names:"-f" usage:"fetch after add"`
+			} `usage:"add remote"`
+		} `usage:"manage remotes"`
+	}
+	var g Git
+
+	set := NewFlagSet(Flag{Names: "git"})
+	set.StructFlags(&g)
+	for _, c := range []struct {
+		level                int
+		remoteV, add, addedF bool
+	}{
+		{0, false, false, false},
+		{1, true, true, false},
+		{2, true, true, true},
+		{-1, true, true, true},
+	} {
+		help := set.ToString(c.level)
+		if strings.Contains(help, "be verbose") != c.remoteV || strings.Contains(help, "add remote") != c.add ||
+			strings.Contains(help, "fetch after add") != c.addedF {
+			t.Fatal("verbose help expanding failed", c.level, help)
+		}
+	}
+
+	for _, c := range []struct {
+		args  []string
+		level int
+	}{
+		{[]string{"git", "-h"}, 0},
+		{[]string{"git", "-h", "-v", "-1"}, -1},
+		{[]string{"git", "remote", "--help", "--verbose=1"}, 1},
+	} {
+		set := NewFlagSet(Flag{Names: "git"}).ErrHandling(0).ExitOnHelp(false)
+		if err := set.ParseStruct(&g, c.args...); err != ErrHelp {
+			t.Fatal("help should be requested", c.args, err)
+		}
+		if set.help.verboseLevel != c.level {
+			t.Fatal("verbose level test failed", c.args, set.help.verboseLevel)
+		}
+	}
+}
+
+func TestVersionFlag(t *testing.T) {
+	var tar Tar
+
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.ParseStruct(&tar, "tar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set.FindFlag("--version"); err != nil {
+		t.Fatal("version flag should be defined", err)
+	}
+
+	set = NewFlagSet(Flag{}).ErrHandling(0).NeedVersionFlag(false)
+	if err := set.ParseStruct(&tar, "tar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set.FindFlag("--version"); err == nil {
+		t.Fatal("version flag should not be defined")
+	}
+
+	type Tool struct {
+		Version string `names:"--version"`
+	}
+	var tool Tool
+	set = NewFlagSet(Flag{Version: "v1.0.0"}).ErrHandling(0)
+	if err := set.ParseStruct(&tool, "tool", "--version", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if tool.Version != "v2" {
+		t.Fatal("user defined version flag should be applied", tool.Version)
+	}
+}
+
+func TestHelpFor(t *testing.T) {
+	var g GoCmd
+
+	set := NewFlagSet(Flag{})
+	set.StructFlags(&g)
+	if err := set.HelpFor("build", 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, children := range []string{"install", "build, -o"} {
+		err := set.HelpFor(children, 0)
+		if err == nil || err.(flagError).Type != errFlagNotFound {
+			t.Fatal("help for invalid children should fail", children, err)
+		}
+	}
+}
+
+func TestFlagKind(t *testing.T) {
+	var tar Tar
+
+	set := NewFlagSet(Flag{})
+	set.StructFlags(&tar)
+	for names, kind := range map[string]Kind{
+		"-z": KindBool,
+		"-f": KindString,
+		"-C": KindString,
+	} {
+		flag, err := set.FindFlag(names)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k := FlagKind(flag); k != kind {
+			t.Errorf("flag kind test failed: %s, expect %s, got %s", names, kind, k)
+		}
+	}
+	if KindIntSlice.String() != "[]int" || !KindIntSlice.IsSlice() || KindIntSlice.Elem() != KindInt {
+		t.Error("slice kind test failed")
+	}
+	if FlagKind(&Flag{Ptr: new(complex64)}) != KindInvalid {
+		t.Error("invalid kind test failed")
+	}
+}
+
+func TestRawBytes(t *testing.T) {
+	type Flags struct {
+		Data  []byte  `names:"-d" raw:"true" default:"a,b"`
+		Bytes []uint8 `names:"-b"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test", "-b", "1", "-b", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(flags.Data) != "a,b" || !reflect.DeepEqual(flags.Bytes, []byte{1, 2}) {
+		t.Fatal("raw bytes default test failed", flags.Data, flags.Bytes)
+	}
+	flag, _ := set.FindFlag("-d")
+	if FlagKind(flag) != KindString {
+		t.Fatal("raw bytes kind test failed", FlagKind(flag))
+	}
+
+	set.Reset()
+	err = set.Parse("test", "-d", "1,2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(flags.Data) != "1,2" {
+		t.Fatal("raw bytes test failed", flags.Data)
+	}
+}
+
+func TestUsageLine(t *testing.T) {
+	var tar Tar
+
+	set := NewFlagSet(Flag{Names: "tar"})
+	set.StructFlags(&tar)
+	set.SetUsageLine(func(f *FlagSet) string {
+		return "-c|-x [-zjJ] -f FILE [FILE]..."
+	})
+	if !strings.Contains(set.String(), "Usage: tar -c|-x [-zjJ] -f FILE [FILE]...\n") {
+		t.Fatal("usage line test failed", set.String())
+	}
+	if line := set.UsageLine(); line != "Usage: tar -c|-x [-zjJ] -f FILE [FILE]..." {
+		t.Fatal("usage line test failed", line)
+	}
+
+	set = NewFlagSet(Flag{Names: "tar"})
+	set.StructFlags(&tar)
+	if line := set.UsageLine(); line != "Usage: tar [FLAG]... [ARG]..." {
+		t.Fatal("usage line test failed", line)
+	}
+}
+
+func TestStrictTags(t *testing.T) {
+	type Flags struct {
+		Output string `names:"-o" usge:"output file"`
+	}
+	type Config struct {
+		Output string `names:"-o" json:"output" usage:"output file"`
+		Sub    struct {
+			Enable bool
+			Input  string `json:"input" yaml:"input"`
+		}
+	}
+
+	err := NewFlagSet(Flag{}).ErrHandling(0).StructFlags(new(Flags))
+	if err != nil {
+		t.Fatal("unknown tags should be ignored by default", err)
+	}
+	err = NewFlagSet(Flag{}).ErrHandling(0).StrictTags(true).StructFlags(new(Flags))
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("unknown tags should be reported", err)
+	}
+	err = NewFlagSet(Flag{}).ErrHandling(0).StrictTags(true, "json").StructFlags(new(Config))
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("unknown tags of subset should be reported", err)
+	}
+	err = NewFlagSet(Flag{}).ErrHandling(0).StrictTags(true, "json", "yaml").StructFlags(new(Config))
+	if err != nil {
+		t.Fatal("ignored tags should not be reported", err)
+	}
+}
+
+func TestFlagTag(t *testing.T) {
+	type Flags struct {
+		GZ     bool   `flag:"names=-z,--gz; usage=gzip format; default=true"`
+		Output string `names:"-o" flag:"names=--output;default=a.out" json:"output"`
+		Sub    struct {
+			Enable bool
+			Input  string `flag:"names=-i"`
+		} `flag:"names=sub;usage=sub command"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test", "sub", "-i", "in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flags.GZ || flags.Output != "a.out" || !flags.Sub.Enable || flags.Sub.Input != "in" {
+		t.Fatal("flag tag test failed", flags)
+	}
+	if _, err := set.FindFlag("-o"); err == nil {
+		t.Fatal("consolidated flag tag should take precedence")
+	}
+	if flag, _ := set.FindFlag("--gz"); flag == nil || flag.Usage != "gzip format" {
+		t.Fatal("flag tag usage test failed")
+	}
+
+	type InvalidFlags struct {
+		Output string `flag:"names=-o;usage"`
+	}
+	err = NewFlagSet(Flag{}).ErrHandling(0).StructFlags(new(InvalidFlags))
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("invalid flag tag should be reported", err)
+	}
+
+	type UnknownFlags struct {
+		Output string `flag:"names=-o;usge=output file" json:"output"`
+	}
+	err = NewFlagSet(Flag{}).ErrHandling(0).StrictTags(true, "json").StructFlags(new(UnknownFlags))
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("unknown key of flag tag should be reported", err)
+	}
+}
+
+func TestOptionalPointer(t *testing.T) {
+	type Flags struct {
+		Port    *int      `names:"-p"`
+		Host    *string   `names:"-H"`
+		Debug   *bool     `names:"-d"`
+		Timeout *float64  `names:"-t" default:"1.5"`
+		Tags    *[]string `names:"--tag"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test", "-p", "0", "-d", "--tag", "a", "--tag", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Port == nil || *flags.Port != 0 || flags.Host != nil {
+		t.Fatal("optional pointer test failed", flags.Port, flags.Host)
+	}
+	if flags.Debug == nil || !*flags.Debug || flags.Timeout == nil || *flags.Timeout != 1.5 {
+		t.Fatal("optional pointer test failed", flags.Debug, flags.Timeout)
+	}
+	if flags.Tags == nil || !reflect.DeepEqual(*flags.Tags, []string{"a", "b"}) {
+		t.Fatal("optional pointer test failed", flags.Tags)
+	}
+	if flag, _ := set.FindFlag("-p"); FlagKind(flag) != KindInt {
+		t.Fatal("optional pointer kind test failed", FlagKind(flag))
+	}
+
+	set.Reset()
+	if flags.Port != nil || flags.Debug != nil || flags.Tags != nil {
+		t.Fatal("optional pointer reset test failed")
+	}
+}
+
+func TestSelectsCaseInsensitive(t *testing.T) {
+	type Flags struct {
+		Format string `names:"--format" selects:"json,yaml" selectsci:"true"`
+		Level  string `names:"--level" selects:"debug,info"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test", "--format", "JSON")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Format != "json" {
+		t.Fatal("case-insensitive selects should be normalized", flags.Format)
+	}
+
+	set.Reset()
+	err = set.Parse("test", "--level", "INFO")
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("selects should be case-sensitive by default", err)
+	}
+}
+
+func TestActiveSubcommand(t *testing.T) {
+	var g Go
+
+	set := NewFlagSet(Flag{Names: "go"}).ErrHandling(0)
+	if err := set.StructFlags(&g); err != nil {
+		t.Fatal(err)
+	}
+	for cmd, path := range map[string][]string{
+		"go":                            {"go"},
+		"go build a.go":                 {"go", "build"},
+		"go tool cover -html=cover.out": {"go", "tool", "cover"},
+	} {
+		args, _ := argv.Argv([]rune(cmd), nil, nil)
+		if err := set.Parse(args[0]...); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(set.ActiveSubcommand(), path) {
+			t.Errorf("active subcommand test failed: %s, expect %v, got %v", cmd, path, set.ActiveSubcommand())
+		}
+		if set.HasSubcommand() != (len(path) > 1) {
+			t.Errorf("has subcommand test failed: %s, got %v", cmd, set.HasSubcommand())
+		}
+		set.Reset()
+	}
+}
+
+func TestParsedKinds(t *testing.T) {
+	type Flags struct {
+		Time      time.Time       `names:"--time" layout:"2006-01-02"`
+		Times     []time.Time     `names:"--times" layout:"2006-01-02"`
+		Duration  time.Duration   `names:"--duration" selects:"1s,1m"`
+		Durations []time.Duration `names:"--durations"`
+		IP        net.IP          `names:"--ip"`
+		IPs       []net.IP        `names:"--ips"`
+		URL       url.URL         `names:"--url"`
+		URLs      []url.URL       `names:"--urls"`
+	}
+	var (
+		date = func(day int) time.Time {
+			return time.Date(2023, 1, day, 0, 0, 0, 0, time.UTC)
+		}
+		u = func(s string) url.URL {
+			u, _ := url.Parse(s)
+			return *u
+		}
+		expect = Flags{
+			Time:      date(1),
+			Times:     []time.Time{date(1), date(2)},
+			Duration:  time.Second,
+			Durations: []time.Duration{time.Second, time.Minute},
+			IP:        net.ParseIP("127.0.0.1"),
+			IPs:       []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+			URL:       u("http://a.com"),
+			URLs:      []url.URL{u("http://a.com"), u("http://b.com")},
+		}
+		sources = map[string]struct {
+			Args []string
+			Tag  string
+		}{
+			"cmdline": {
+				Args: []string{"--time", "2023-01-01", "--times", "2023-01-01", "--times", "2023-01-02",
+					"--duration", "1000ms", "--durations", "1s", "--durations", "1m",
+					"--ip", "127.0.0.1", "--ips", "127.0.0.1", "--ips", "::1",
+					"--url", "http://a.com", "--urls", "http://a.com", "--urls", "http://b.com",
+				},
+			},
+			"env": {
+				Tag: "env",
+			},
+			"default": {
+				Tag: "default",
+			},
+		}
+		values = map[string]string{
+			"--time":      "2023-01-01",
+			"--times":     "2023-01-01,2023-01-02",
+			"--duration":  "1s",
+			"--durations": "1s,1m",
+			"--ip":        "127.0.0.1",
+			"--ips":       "127.0.0.1,::1",
+			"--url":       "http://a.com",
+			"--urls":      "http://a.com,http://b.com",
+		}
+	)
+	defer func() {
+		envParser = os.Getenv
+	}()
+
+	for name, source := range sources {
+		var flags Flags
+		set := NewFlagSet(Flag{}).ErrHandling(0)
+		if err := set.StructFlags(&flags); err != nil {
+			t.Fatal(err)
+		}
+		switch source.Tag {
+		case "env":
+			envParser = func(name string) string {
+				return values[name]
+			}
+			for names := range values {
+				set.UpdateMeta(names, Flag{Env: names})
+			}
+		case "default":
+			envParser = os.Getenv
+			for names, val := range values {
+				flag, _ := set.FindFlag(names)
+				def, err := parseDefault(flag, val)
+				if err != nil {
+					t.Fatal(name, err)
+				}
+				set.UpdateMeta(names, Flag{Default: def})
+			}
+		}
+		if err := set.Parse(append([]string{"test"}, source.Args...)...); err != nil {
+			t.Fatal(name, err)
+		}
+		if !reflect.DeepEqual(flags, expect) {
+			t.Errorf("%s: parsed kinds test failed: expect %+v, got %+v", name, expect, flags)
+		}
+	}
+
+	err := NewFlagSet(Flag{}).ErrHandling(0).ParseStruct(new(Flags), "test", "--duration", "1h")
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("duration selects test failed", err)
+	}
+	for _, args := range [][]string{{"--ip", "localhost"}, {"--durations", "1"}, {"--url", "%"}} {
+		err := NewFlagSet(Flag{}).ErrHandling(0).ParseStruct(new(Flags), append([]string{"test"}, args...)...)
+		if err == nil || err.(flagError).Type != errInvalidValue {
+			t.Fatal("parsed kinds error test failed", args, err)
+		}
+	}
+}
+
+func TestHelpRequested(t *testing.T) {
+	var tar Tar
+
+	set := NewFlagSet(Flag{}).ErrHandling(0).ExitOnHelp(false)
+	if err := set.ParseStruct(&tar, "tar", "-z"); err != nil || set.HelpRequested() {
+		t.Fatal("help should not be requested", err)
+	}
+	set.Reset()
+	if err := set.Parse("tar", "-h"); err != ErrHelp || !set.HelpRequested() {
+		t.Fatal("help should be requested", err)
+	}
+	set.Reset()
+	if err := set.Parse("tar", "--version"); err != ErrVersion {
+		t.Fatal("version should be requested", err)
+	}
+	set.Reset()
+	if err := set.Parse("tar"); err != nil || set.HelpRequested() {
+		t.Fatal("help should not be requested", err)
+	}
+}
+
+func TestHelpTemplate(t *testing.T) {
+	var g GoCmd
+
+	set := NewFlagSet(Flag{Names: "go"}).ErrHandling(0)
+	set.StructFlags(&g)
+	if err := set.SetHelpTemplate("{{.Names}}: {{range .Commands}}{{.Names}} {{end}}"); err != nil {
+		t.Fatal(err)
+	}
+	if s := set.String(); s != "go: build clean doc env bug fix fmt " {
+		t.Fatal("help template test failed", s)
+	}
+	build, _ := set.FindSubset("build")
+	if s := build.String(); s != "build: " {
+		t.Fatal("help template should be applied to subsets", s)
+	}
+
+	if err := set.SetHelpTemplate(DefaultHelpTemplate); err != nil {
+		t.Fatal(err)
+	}
+	if s := build.String(); !strings.Contains(s, "Usage: build [-o output] [-i] [build flags] [packages]") ||
+		!strings.Contains(s, "-o output") {
+		t.Fatal("default help template test failed", s)
+	}
+
+	if err := set.SetHelpTemplate("{{.Names"); err == nil {
+		t.Fatal("invalid help template should be reported")
+	}
+	if err := set.SetHelpTemplate("{{.Names}} {{.Unknown}}"); err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("template failed to execute should be reported", err)
+	}
+	if s := build.String(); !strings.Contains(s, "Usage: build") {
+		t.Fatal("previous template should be kept if template is invalid", s)
+	}
+	set.setHelpTemplate(template.Must(template.New("help").Parse("{{.Unknown}}")))
+	if s := build.String(); !strings.Contains(s, "Usage: build") || strings.Contains(s, "Unknown") {
+		t.Fatal("builtin help message should be used if template fails", s)
+	}
+}
+
+func TestAttachOnly(t *testing.T) {
+	type Flags struct {
+		Color string   `names:"--color" attachonly:"true" default:"auto"`
+		Level string   `names:"--level" attachonly:"true"`
+		Args  []string `args:"true"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test", "--color", "always")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Color != "auto" || !reflect.DeepEqual(flags.Args, []string{"always"}) {
+		t.Fatal("attach-only flag should not consume next argument", flags.Color, flags.Args)
+	}
+
+	flags = Flags{}
+	set.Reset()
+	err = set.Parse("test", "--color=never", "--level=debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Color != "never" || flags.Level != "debug" {
+		t.Fatal("attached value should be applied", flags.Color, flags.Level)
+	}
+
+	set.Reset()
+	err = set.Parse("test", "--level", "debug")
+	if err == nil || err.(flagError).Type != errFlagValueNotProvided {
+		t.Fatal("attach-only flag without default should require attached value", err)
+	}
+}
+
+func TestVarHelpers(t *testing.T) {
+	var (
+		n       int
+		ns      []int
+		s       string
+		ss      []string
+		b       bool
+		timeout time.Duration
+	)
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	for _, err := range []error{
+		set.IntVar(&n, "-n", "count"),
+		set.IntsVar(&ns, "--ns", "numbers"),
+		set.StringVar(&s, "-s,--str", "string"),
+		set.StringsVar(&ss, "--ss", "strings"),
+		set.BoolVar(&b, "-b", "bool"),
+		set.DurationVar(&timeout, "--timeout", "timeout"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := set.IntVar(&n, "-n", "count"); err == nil {
+		t.Fatal("duplicate flag should be reported")
+	}
+
+	err := set.Parse("test", "-n", "1", "--ns", "1", "--ns", "2", "--str", "a", "--ss", "b", "-b", "--timeout", "3s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || !reflect.DeepEqual(ns, []int{1, 2}) || s != "a" || !reflect.DeepEqual(ss, []string{"b"}) || !b || timeout != 3*time.Second {
+		t.Fatal("unexpected values", n, ns, s, ss, b, timeout)
+	}
+}
+
+func TestDefaultFlagRef(t *testing.T) {
+	type Flags struct {
+		DataDir  string   `names:"--data-dir" default:"/var/data"`
+		CacheDir string   `names:"--cache-dir" default:"${data-dir}/cache"`
+		TmpDir   string   `names:"--tmp-dir" default:"${cache-dir}/tmp"`
+		Dirs     []string `names:"--dirs" default:"${data-dir},${--tmp-dir}"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.CacheDir != "/var/data/cache" || flags.TmpDir != "/var/data/cache/tmp" ||
+		!reflect.DeepEqual(flags.Dirs, []string{"/var/data", "/var/data/cache/tmp"}) {
+		t.Fatal("default value references should be resolved", flags)
+	}
+
+	flags = Flags{}
+	set.Reset()
+	err = set.Parse("test", "--data-dir", "/data", "--tmp-dir", "/tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.CacheDir != "/data/cache" || flags.TmpDir != "/tmp" {
+		t.Fatal("default value references should use parsed values", flags)
+	}
+
+	type CycleFlags struct {
+		A string `names:"--a" default:"${b}"`
+		B string `names:"--b" default:"${a}"`
+		C string `names:"--c" default:"${unknown}"`
+	}
+	var cycle CycleFlags
+	set = NewFlagSet(Flag{}).ErrHandling(0)
+	err = set.ParseStruct(&cycle, "test")
+	if err == nil || err.(flagError).Type != errInvalidDefault {
+		t.Fatal("cyclic default value reference should be reported", err)
+	}
+	set.Reset()
+	err = set.Parse("test", "--a", "a", "--b", "b")
+	if err == nil || err.(flagError).Type != errFlagNotFound {
+		t.Fatal("unknown flag reference should be reported", err)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	envParser = func(name string) string {
+		return map[string]string{
+			"HOME":            "/home/user",
+			"XDG_CONFIG_HOME": "/home/user/.config",
+		}[name]
+	}
+	defer func() {
+		envParser = os.Getenv
+	}()
+
+	type Flags struct {
+		Config string   `names:"--config" default:"${XDG_CONFIG_HOME}/app"`
+		Cache  string   `names:"--cache" default:"${config}/cache"`
+		Paths  []string `names:"--paths"`
+		Price  string   `names:"--price"`
+		Count  int      `names:"--count" default:"1"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0).ExpandEnv(true)
+	err := set.ParseStruct(&flags, "test", "--paths", "$HOME/a", "--paths", "${HOME}/b", "--price", "$$10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Config != "/home/user/.config/app" || flags.Cache != "/home/user/.config/app/cache" ||
+		!reflect.DeepEqual(flags.Paths, []string{"/home/user/a", "/home/user/b"}) || flags.Price != "$10" {
+		t.Fatal("environment variables should be expanded", flags)
+	}
+
+	flags = Flags{}
+	set.ExpandEnv(false).Reset()
+	err = set.Parse("test", "--config", "$HOME")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Config != "$HOME" {
+		t.Fatal("environment variables should not be expanded by default", flags.Config)
+	}
+}
+
+func TestBundling(t *testing.T) {
+	type Flags struct {
+		A     bool   `names:"-a"`
+		B     bool   `names:"-b"`
+		C     bool   `names:"-c"`
+		ABC   string `names:"-abc"`
+		Level string `names:"-l"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test")
+	if err == nil || err.(flagError).Type != errDuplicateFlagRegister {
+		t.Fatal("ambiguous bundle should be reported if bundling is enabled", err)
+	}
+
+	for _, args := range [][]string{
+		{"test", "-abc", "v", "-a"},
+		{"test", "-abc=v", "-a"},
+	} {
+		flags = Flags{}
+		set = NewFlagSet(Flag{}).ErrHandling(0).Bundling(false)
+		err = set.ParseStruct(&flags, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flags.ABC != "v" || !flags.A || flags.B {
+			t.Fatal("flag should not be splitted if bundling is disabled", args, flags)
+		}
+	}
+
+	set.Reset()
+	err = set.Parse("test", "-ab")
+	if err == nil || err.(flagError).Type != errFlagNotFound {
+		t.Fatal("flag should not be splitted if bundling is disabled", err)
+	}
+	set.Reset()
+	err = set.Parse("test", "-ldebug")
+	if err == nil || err.(flagError).Type != errFlagNotFound {
+		t.Fatal("flag should not take remain characters as value if bundling is disabled", err)
+	}
+}
+
+func TestSetErrHandling(t *testing.T) {
+	type Flags struct {
+		Force bool `names:"--force"`
+		Rm    struct {
+			Enable bool
+			Depth  int `names:"--depth"`
+		}
+		Ls struct {
+			Enable bool
+			Depth  int `names:"--depth"`
+		}
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm, _ := set.FindSubset("rm")
+	rm.SetErrHandling(ErrPanic)
+	ls, _ := set.FindSubset("ls")
+	if ls.errorHandling != 0 || set.errorHandling != 0 {
+		t.Fatal("SetErrHandling should only change current flagset")
+	}
+
+	err = set.Parse("test", "ls", "--depth", "a")
+	if err == nil {
+		t.Fatal("invalid value should be reported")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("error of rm should panic")
+			}
+		}()
+		set.Reset()
+		set.Parse("test", "rm", "--depth", "a")
+	}()
+
+	set.ErrHandling(0)
+	if rm.errorHandling != 0 {
+		t.Fatal("ErrHandling should overwrite subsets")
+	}
+}
+
+type NoFlagEmbedded struct {
+	Secret string
+}
+
+func (NoFlagEmbedded) NoFlag() {}
+
+func TestAnonymousFields(t *testing.T) {
+	type Common struct {
+		Verbose bool `names:"--verbose"`
+	}
+	type Extra struct {
+		Extra string
+	}
+	type Config struct {
+		sync.Mutex
+		fmt.Stringer
+		*Extra
+		NoFlagEmbedded
+		Common
+
+		Name string `names:"--name"`
+	}
+
+	var conf Config
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&conf, "test", "--name", "a", "--verbose")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Name != "a" || !conf.Verbose {
+		t.Fatal("embedded structure should be flattened", conf.Name, conf.Common)
+	}
+	for _, name := range []string{"-mutex", "-stringer", "-secret", "-state", "-extra"} {
+		if flag, _ := set.FindFlag(name); flag != nil {
+			t.Fatal("anonymous field should be ignored:", name)
+		}
+	}
+}
+
+type unexportedEmbedded struct {
+	Depth   int `names:"--depth"`
+	private int
+}
+
+type unexportedNoFlag struct {
+	Secret string
+}
+
+func (unexportedNoFlag) NoFlag() {}
+
+func TestUnexportedEmbedded(t *testing.T) {
+	type Flags struct {
+		unexportedEmbedded
+		unexportedNoFlag
+
+		Name string `names:"--name"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test", "--name", "a", "--depth", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "a" || flags.Depth != 3 {
+		t.Fatal("exported fields of unexported embedded structure should be registered", flags)
+	}
+	for _, name := range []string{"-private", "-secret"} {
+		if flag, _ := set.FindFlag(name); flag != nil {
+			t.Fatal("field should be ignored:", name)
+		}
+	}
+}
+
+func TestNegativeNumber(t *testing.T) {
+	type Flags struct {
+		N    int     `names:"-n"`
+		Num  float64 `names:"--num"`
+		Nums []int   `names:"--nums"`
+		Expr string  `names:"-e"`
+		Bool bool    `names:"-b"`
+	}
+
+	for _, c := range []struct {
+		Args   []string
+		Expect Flags
+	}{
+		{Args: []string{"test", "-n", "-5"}, Expect: Flags{N: -5}},
+		{Args: []string{"test", "--num", "-5"}, Expect: Flags{Num: -5}},
+		{Args: []string{"test", "--num=-5"}, Expect: Flags{Num: -5}},
+		{Args: []string{"test", "--num", "-3.14", "-n", "-.5e1"}, Expect: Flags{Num: -3.14, N: -5}},
+		{Args: []string{"test", "--nums", "-1", "--nums", "-2"}, Expect: Flags{Nums: []int{-1, -2}}},
+		{Args: []string{"test", "-e", "-1"}, Expect: Flags{Expr: "-1"}},
+	} {
+		var flags Flags
+		set := NewFlagSet(Flag{}).ErrHandling(0)
+		err := set.ParseStruct(&flags, c.Args...)
+		if err != nil {
+			t.Fatal(c.Args, err)
+		}
+		if !reflect.DeepEqual(flags, c.Expect) {
+			t.Fatal("negative number should be parsed as value", c.Args, flags)
+		}
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test", "-b", "-5")
+	if err == nil || err.(flagError).Type != errFlagNotFound {
+		t.Fatal("negative number after bool flag should not be treated as value", err)
+	}
+}
+
+func TestTypedArgs(t *testing.T) {
+	type Flags struct {
+		Op   string    `names:"--op"`
+		Args []float64 `args:"true"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "calc", "--op", "sum", "1", "2.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Args, []float64{1, 2.5}) {
+		t.Fatal("non-flag arguments should be converted", flags.Args)
+	}
+
+	set.Reset()
+	err = set.Parse("calc", "1", "a")
+	if err == nil || err.(flagError).Type != errInvalidValue || !strings.Contains(err.Error(), " a,") {
+		t.Fatal("invalid non-flag argument should be reported", err)
+	}
+
+	type InvalidFlags struct {
+		Args []map[string]string `args:"true"`
+	}
+	err = NewFlagSet(Flag{}).ErrHandling(0).StructFlags(&InvalidFlags{})
+	if err == nil || err.(flagError).Type != errInvalidType {
+		t.Fatal("unsupported args type should be reported", err)
+	}
+}
+
+var finalizeOrder []string
+
+type finalizeRemote struct {
+	Enable bool
+	Host   string `names:"--host"`
+	URL    string `names:"-"`
+}
+
+func (r *finalizeRemote) Finalize() error {
+	finalizeOrder = append(finalizeOrder, "remote")
+	if r.Host == "" {
+		return fmt.Errorf("host is required")
+	}
+	r.URL = "http://" + r.Host
+	return nil
+}
+
+type finalizeLocal struct {
+	Enable bool
+}
+
+func (l *finalizeLocal) Finalize() error {
+	finalizeOrder = append(finalizeOrder, "local")
+	return nil
+}
+
+type finalizeFlags struct {
+	Verbose bool `names:"--verbose"`
+	Remote  finalizeRemote
+	Local   finalizeLocal
+}
+
+func (f *finalizeFlags) Finalize() error {
+	finalizeOrder = append(finalizeOrder, "root")
+	return nil
+}
+
+func TestFinalizer(t *testing.T) {
+	var flags finalizeFlags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test", "remote", "--host", "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Remote.URL != "http://localhost" {
+		t.Fatal("finalizer should be called", flags.Remote.URL)
+	}
+	if !reflect.DeepEqual(finalizeOrder, []string{"root", "remote"}) {
+		t.Fatal("finalizers should be called in parent-to-child order for enabled subsets", finalizeOrder)
+	}
+
+	finalizeOrder = nil
+	set.Reset()
+	err = set.Parse("test", "remote")
+	if err == nil || err.Error() != "host is required" {
+		t.Fatal("finalizer error should be returned", err)
+	}
+}
+
+func TestDuplicateMessage(t *testing.T) {
+	set := NewFlagSet(Flag{Names: "tool"}).ErrHandling(0)
+	var (
+		remote, force bool
+		status        string
+	)
+	err := set.Flag(Flag{Names: "--remote", Ptr: &remote})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = set.Subset(Flag{Names: "--remote", Ptr: new(bool)})
+	if err == nil || err.Error() != "subset name collides with flag at level tool: tool.--remote" {
+		t.Fatal("subset collides with flag should be reported precisely", err)
+	}
+
+	repo, err := set.Subset(Flag{Names: "repo", Ptr: new(bool)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := repo.Subset(Flag{Names: "status", Ptr: new(bool)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sub.Flag(Flag{Names: "--force", Ptr: &force})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Flag(Flag{Names: "status", Ptr: &status})
+	if err == nil || err.Error() != "flag name collides with subset at level tool.repo: tool.repo.status" {
+		t.Fatal("flag collides with subset should be reported precisely", err)
+	}
+}
+
+func TestArgsDefault(t *testing.T) {
+	type Flags struct {
+		Recursive bool     `names:"-r"`
+		Dirs      []string `args:"true" argsdefault:"., src"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test", "-r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Dirs, []string{".", "src"}) {
+		t.Fatal("default non-flag arguments should be applied", flags.Dirs)
+	}
+
+	set.Reset()
+	err = set.Parse("test", "-r", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Dirs, []string{"a"}) {
+		t.Fatal("non-flag arguments should override default", flags.Dirs)
+	}
+}
+
+func TestArgsEnv(t *testing.T) {
+	envParser = func(name string) string {
+		if name == "MYTOOL_ARGS" {
+			return "a  b c"
+		}
+		return ""
+	}
+	defer func() {
+		envParser = os.Getenv
+	}()
+
+	type Flags struct {
+		Args []string `env:"MYTOOL_ARGS" valsep:" " argsdefault:"."`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Args, []string{"a", "b", "c"}) {
+		t.Fatal("environment non-flag arguments should be applied", flags.Args)
+	}
+
+	set.Reset()
+	err = set.Parse("test", "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Args, []string{"d"}) {
+		t.Fatal("non-flag arguments should override environment", flags.Args)
+	}
+
+	envParser = os.Getenv
+	set.Reset()
+	err = set.Parse("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Args, []string{"."}) {
+		t.Fatal("default should be used if environment is empty", flags.Args)
+	}
+}
+
+func TestDumpValues(t *testing.T) {
+	envParser = func(name string) string {
+		if name == "LEVEL" {
+			return "debug"
+		}
+		return ""
+	}
+	defer func() {
+		envParser = os.Getenv
+	}()
+
+	type Flags struct {
+		Name  string   `names:"--name"`
+		Level string   `names:"--level" env:"LEVEL" default:"info"`
+		Tags  []string `names:"--tags" default:"a,b"`
+		Count *int     `names:"--count"`
+		Sub   struct {
+			Enable bool
+			Host   string `names:"--host"`
+		}
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0).NeedHelpFlag(false)
+	err := set.ParseStruct(&flags, "test", "--name", "a", "sub", "--host", "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	err = set.DumpValues(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `--name=a (command line)
+--level=debug (environment)
+--tags=a,b (default)
+--count= (unset)
+sub.--host=localhost (command line)
+`
+	if buf.String() != expect {
+		t.Fatal("unexpected dump result:\n" + buf.String())
+	}
+
+	var count int
+	set.VisitAll(func(path []string, flag *Flag) {
+		count++
+	})
+	if count != 5 {
+		t.Fatal("VisitAll should visit all flags recursively", count)
+	}
+}
+
+func TestSplitValues(t *testing.T) {
+	for _, c := range []struct {
+		Value  string
+		Sep    string
+		Expect []string
+	}{
+		{`a,b\,c`, ",", []string{"a", "b,c"}},
+		{`a, "b,c" , d`, ",", []string{"a", "b,c", "d"}},
+		{`a,' b,c '`, ",", []string{"a", " b,c "}},
+		{`a\\,b`, ",", []string{`a\`, "b"}},
+		{`don't,x`, ",", []string{"don't", "x"}},
+		{`a  "b c"  d`, " ", []string{"a", "b c", "d"}},
+		{`a,,b`, ",", []string{"a", "", "b"}},
+	} {
+		got := splitValues(c.Value, c.Sep)
+		if !reflect.DeepEqual(got, c.Expect) {
+			t.Fatalf("split %s: expect %q, got %q", c.Value, c.Expect, got)
+		}
+	}
+
+	envParser = func(name string) string {
+		if name == "TAGS" {
+			return `a,b\,c`
+		}
+		return ""
+	}
+	defer func() {
+		envParser = os.Getenv
+	}()
+	type Flags struct {
+		Tags     []string `names:"--tags" env:"TAGS"`
+		Defaults []string `names:"--defaults" default:"a,\"b,c\""`
+	}
+	var flags Flags
+	err := NewFlagSet(Flag{}).ErrHandling(0).ParseStruct(&flags, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Tags, []string{"a", "b,c"}) || !reflect.DeepEqual(flags.Defaults, []string{"a", "b,c"}) {
+		t.Fatal("escaped or quoted separators should be kept", flags.Tags, flags.Defaults)
+	}
+}
+
+func TestFlagDependency(t *testing.T) {
+	type Flags struct {
+		TLSCert string `names:"--tls-cert" requires:"tls-key"`
+		TLSKey  string `names:"--tls-key" default:"key.pem"`
+		User    string `names:"--user"`
+		Pass    string `names:"--pass"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.RequireTogether("user", "--pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.RequireTogether("user", "unknown")
+	if err == nil || err.(flagError).Type != errFlagNotFound {
+		t.Fatal("unknown flag should be reported", err)
+	}
+
+	for _, c := range []struct {
+		Args []string
+		Err  string
+	}{
+		{Args: []string{"test"}},
+		{Args: []string{"test", "--tls-cert", "a.pem", "--tls-key", "b.pem"}},
+		{Args: []string{"test", "--tls-key", "b.pem"}},
+		{Args: []string{"test", "--tls-cert", "a.pem"}, Err: "flag [test].--tls-cert requires --tls-key"},
+		{Args: []string{"test", "--user", "a", "--pass", "b"}},
+		{Args: []string{"test", "--pass", "b"}, Err: "flag [test].--pass must be set together with --user"},
+	} {
+		set.Reset()
+		err = set.Parse(c.Args...)
+		if c.Err == "" {
+			if err != nil {
+				t.Fatal(c.Args, err)
+			}
+			continue
+		}
+		if err == nil || err.(flagError).Type != errFlagDependency || err.Error() != c.Err {
+			t.Fatal("flag dependency should be checked", c.Args, err)
+		}
+	}
+}
+
+func TestRequireOneOf(t *testing.T) {
+	type Flags struct {
+		JSON  bool   `names:"--json"`
+		YAML  bool   `names:"--yaml"`
+		Table bool   `names:"--table"`
+		User  string `names:"--user"`
+		Pass  string `names:"--pass"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = set.RequireOneOf("json", "yaml", "table"); err != nil {
+		t.Fatal(err)
+	}
+	if err = set.RequireTogether("user", "pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		Args    []string
+		ErrType errorType
+		Err     string
+	}{
+		{Args: []string{"test", "--json"}},
+		{Args: []string{"test", "--table", "--user", "a", "--pass", "b"}},
+		{Args: []string{"test"}, ErrType: errFlagDependency, Err: "one of flags must be set: [test].[--json|--yaml|--table]"},
+		{Args: []string{"test", "--json", "--yaml"}, ErrType: errFlagConflict, Err: "only one of flags could be set: [test].[--json|--yaml]"},
+		{Args: []string{"test", "--json", "--user", "a"}, ErrType: errFlagDependency},
+	} {
+		set.Reset()
+		err = set.Parse(c.Args...)
+		if c.ErrType == 0 {
+			if err != nil {
+				t.Fatal(c.Args, err)
+			}
+			continue
+		}
+		if err == nil || err.(flagError).Type != c.ErrType || (c.Err != "" && err.Error() != c.Err) {
+			t.Fatal("flag group should be checked", c.Args, err)
+		}
+	}
+}
+
+func TestPrintUsageOnError(t *testing.T) {
+	captureStderr := func(fn func()) string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stderr := os.Stderr
+		os.Stderr = w
+		fn()
+		os.Stderr = stderr
+		w.Close()
+
+		var buf strings.Builder
+		b := make([]byte, 1024)
+		for {
+			n, err := r.Read(b)
+			buf.Write(b[:n])
+			if err != nil {
+				break
+			}
+		}
+		r.Close()
+		return buf.String()
+	}
+
+	type Flags struct {
+		Count int `names:"-n"`
+	}
+	var flags Flags
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(ErrPrint)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := captureStderr(func() {
+		set.Parse("test", "-n", "a")
+	})
+	if strings.Contains(output, "Usage:") {
+		t.Fatal("usage line should not be printed by default", output)
+	}
+
+	set.PrintUsageOnError(true)
+	set.Reset()
+	output = captureStderr(func() {
+		set.Parse("test", "-n", "a")
+	})
+	if !strings.HasSuffix(output, "\nUsage: test [FLAG]...\n") {
+		t.Fatal("usage line should be printed after error", output)
+	}
+}
+
+func TestBoolDefaultTrue(t *testing.T) {
+	type Flags struct {
+		Feature bool     `names:"--feature" default:"true"`
+		Args    []string `args:"true"`
+	}
+
+	for _, c := range []struct {
+		Args    []string
+		Feature bool
+		Rest    []string
+	}{
+		{Args: []string{"test"}, Feature: true},
+		{Args: []string{"test", "--feature"}, Feature: true},
+		{Args: []string{"test", "--feature=true"}, Feature: true},
+		{Args: []string{"test", "--feature=false"}, Feature: false},
+		{Args: []string{"test", "--feature", "false"}, Feature: true, Rest: []string{"false"}},
+	} {
+		var flags Flags
+		err := NewFlagSet(Flag{}).ErrHandling(0).ParseStruct(&flags, c.Args...)
+		if err != nil {
+			t.Fatal(c.Args, err)
+		}
+		if flags.Feature != c.Feature || !reflect.DeepEqual(flags.Args, c.Rest) {
+			t.Fatal("bool flag with default true test failed", c.Args, flags)
+		}
+	}
+}
+
+func TestRebindStructure(t *testing.T) {
+	type Flags struct {
+		Name string   `names:"--name"`
+		Args []string `args:"true"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.StructFlags(&flags)
+	if err == nil || err.(flagError).Type != errInvalidStructure {
+		t.Fatal("re-registration of structure should be reported", err)
+	}
+
+	_, err = set.Subset(Flag{Names: "sub", Ptr: new(bool), ArgsPtr: &flags.Args})
+	if err == nil || err.(flagError).Type != errDuplicateFlagRegister || !strings.Contains(err.Error(), "already bound") {
+		t.Fatal("aliasing of args pointer should be reported", err)
+	}
+
+	var other Flags
+	err = NewFlagSet(Flag{Names: "test"}).ErrHandling(0).StructFlags(&other)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitTag(t *testing.T) {
+	type Flags struct {
+		Ports []int    `names:"--ports" split:"true"`
+		Hosts []string `names:"--hosts" split:"true" valsep:";"`
+		Tags  []string `names:"--tags"`
+	}
+
+	var flags Flags
+	err := NewFlagSet(Flag{}).ErrHandling(0).ParseStruct(&flags, "test",
+		"--ports", "80,443", "--ports=8080", "--hosts", "a;b", "--tags", "a,b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Ports, []int{80, 443, 8080}) ||
+		!reflect.DeepEqual(flags.Hosts, []string{"a", "b"}) ||
+		!reflect.DeepEqual(flags.Tags, []string{"a,b"}) {
+		t.Fatal("split tag test failed", flags)
+	}
+}
+
+func TestScan(t *testing.T) {
+	type Flags struct {
+		Verbose bool `names:"-v"`
+		Remote  struct {
+			Enable bool
+			Add    struct {
+				Enable bool
+				Force  bool   `names:"-f"`
+				Name   string `names:"-n"`
+			}
+		}
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{Names: "git"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := set.Scan([]string{"git", "-v", "remote", "add", "-fn", "origin", "--", "-a", "-n=b"})
+	if flags.Verbose {
+		t.Fatal("values should not be applied by Scan")
+	}
+	if !reflect.DeepEqual(result.Path, []string{"remote", "add"}) || result.Subcmd != "remote" {
+		t.Fatal("subcommand path test failed", result.Path, result.Subcmd)
+	}
+	expect := []ScanToken{
+		{Value: "add", IsFlag: true},
+		{Value: "-f", IsFlag: true, Cluster: "-fn"},
+		{Value: "-n", IsFlag: true},
+		{Value: "origin"},
+		{Value: "-a"},
+		{Value: "-n", IsFlag: true, Attached: "b", HasAttached: true},
+	}
+	add := result.Subsets["remote"].Subsets["add"]
+	if add == nil || !reflect.DeepEqual(add.Tokens, expect) {
+		t.Fatal("scanned tokens test failed", add)
+	}
+	if !reflect.DeepEqual(result.Tokens, []ScanToken{{Value: "git", IsFlag: true}, {Value: "-v", IsFlag: true}}) {
+		t.Fatal("scanned tokens test failed", result.Tokens)
+	}
+	result = set.Scan([]string{"git", "--unknown", "remote", "rm"})
+	if result.Subcmd != "remote" || len(result.Tokens) != 2 || result.Tokens[1].Value != "--unknown" {
+		t.Fatal("unknown tokens should be kept without validation", result.Tokens, result.Subcmd)
+	}
+}
+
+func TestSuggestCommands(t *testing.T) {
+	type Flags struct {
+		Name   string `names:"--name"`
+		Remote struct {
+			Enable bool
+		}
+		Status struct {
+			Enable bool
+		}
+		Args []string `args:"true"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{Names: "git"}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "git", "remot")
+	if err != nil || !reflect.DeepEqual(flags.Args, []string{"remot"}) {
+		t.Fatal("unknown command should be treated as non-flag argument by default", err, flags.Args)
+	}
+
+	set.SuggestCommands(true)
+	for _, c := range []struct {
+		Args []string
+		Err  string
+	}{
+		{Args: []string{"git", "--name", "remot", "status"}},
+		{Args: []string{"git", "--name", "a", "remot"}, Err: "unknown command: [git].remot; did you mean remote?"},
+		{Args: []string{"git", "frobnicate"}, Err: "unknown command: [git].frobnicate"},
+	} {
+		set.Reset()
+		err = set.Parse(c.Args...)
+		if c.Err == "" {
+			if err != nil {
+				t.Fatal(c.Args, err)
+			}
+			continue
+		}
+		if err == nil || err.(flagError).Type != errUnknownCommand || err.Error() != c.Err {
+			t.Fatal("unknown command should be reported", c.Args, err)
+		}
+	}
+}
+
+func TestSuggestFlags(t *testing.T) {
+	type Flags struct {
+		Timeout int  `names:"--timeout"`
+		Force   bool `names:"-f, --force"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test", "--tiemout", "1")
+	if err == nil || err.Error() != "unsupported flag: [test].--tiemout" {
+		t.Fatal("suggestion should be disabled by default", err)
+	}
+
+	set.SuggestFlags(true)
+	for _, c := range []struct {
+		Flag string
+		Err  string
+	}{
+		{Flag: "--tiemout", Err: "unsupported flag: [test].--tiemout; did you mean --timeout?"},
+		{Flag: "--froce", Err: "unsupported flag: [test].--froce; did you mean --force?"},
+		{Flag: "--interval", Err: "unsupported flag: [test].--interval"},
+	} {
+		set.Reset()
+		err = set.Parse("test", c.Flag)
+		if err == nil || err.(flagError).Type != errFlagNotFound || err.Error() != c.Err {
+			t.Fatal("flag suggestion test failed", c.Flag, err)
+		}
+	}
+}
+
+func TestDefaultFunc(t *testing.T) {
+	type Flags struct {
+		Dir   string   `names:"--dir"`
+		Count int      `names:"--count" default:"1"`
+		Tags  []string `names:"--tags"`
+		Bad   int      `names:"--bad"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	for children, fn := range map[string]func() interface{}{
+		"--dir": func() interface{} {
+			calls++
+			return "/work"
+		},
+		"--count": func() interface{} { return 2 },
+		"--tags":  func() interface{} { return []string{"a", "b"} },
+	} {
+		err = set.UpdateMeta(children, Flag{DefaultFunc: fn})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = set.Parse("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Dir != "/work" || flags.Count != 1 || !reflect.DeepEqual(flags.Tags, []string{"a", "b"}) {
+		t.Fatal("default function should be used if no static default", flags)
+	}
+
+	set.Reset()
+	err = set.Parse("test", "--dir", "/tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Dir != "/tmp" || calls != 1 {
+		t.Fatal("default function should not be called if value is provided", flags.Dir, calls)
+	}
+
+	err = set.UpdateMeta("--bad", Flag{DefaultFunc: func() interface{} { return "a" }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.Reset()
+	err = set.Parse("test")
+	if err == nil || err.(flagError).Type != errInvalidType {
+		t.Fatal("incompatible default function result should be reported", err)
+	}
+}
+
+// writeTempFile write content to file with name inside a new temporary directory, the directory
+// should be removed by removeTempFile.
+func writeTempFile(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "flag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func removeTempFile(path string) {
+	os.RemoveAll(filepath.Dir(path))
+}
+
+type configFlags struct {
+	Name    string        `names:"--name"`
+	Port    int           `names:"-p, --port" default:"80"`
+	Tags    []string      `names:"--tags"`
+	Debug   bool          `names:"--debug"`
+	Timeout time.Duration `names:"--timeout"`
+	Remote  struct {
+		Enable bool
+		Host   string `names:"--host"`
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	path := writeTempFile(t, "flag.toml", `
+name = "app"
+port = 8080
+tags = ["a", "b"]
+debug = true
+timeout = "3s"
+
+[remote]
+host = "localhost"
+`)
+	defer removeTempFile(path)
+
+	var flags configFlags
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.ParseTOML(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "--name", "cmd", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "cmd" || flags.Port != 8080 || !reflect.DeepEqual(flags.Tags, []string{"a", "b"}) ||
+		!flags.Debug || flags.Timeout != 3*time.Second || flags.Remote.Host != "localhost" {
+		t.Fatal("config values should be applied", flags)
+	}
+
+	for _, c := range []struct {
+		Content string
+		ErrType errorType
+	}{
+		{Content: `unknown = 1`, ErrType: errFlagNotFound},
+		{Content: "[unknown]\nhost = \"a\"", ErrType: errFlagNotFound},
+		{Content: `port = "80"`, ErrType: errInvalidValue},
+		{Content: `name = ["a"]`, ErrType: errInvalidValue},
+		{Content: `timeout = "a"`, ErrType: errInvalidValue},
+	} {
+		path := writeTempFile(t, "flag.toml", c.Content)
+		err = set.ParseTOML(path)
+		removeTempFile(path)
+		if err == nil || err.(flagError).Type != c.ErrType {
+			t.Fatal("invalid config should be reported", c.Content, err)
+		}
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	path := writeTempFile(t, "flag.json", `{"name": "app", "--port": 8080, "tags": ["a"], "remote": {"host": "localhost"}}`)
+	defer removeTempFile(path)
+
+	var flags configFlags
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.ParseJSON(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "--tags", "b", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "app" || flags.Port != 8080 || !reflect.DeepEqual(flags.Tags, []string{"b"}) || flags.Remote.Host != "localhost" {
+		t.Fatal("config values should be applied", flags)
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	path := writeTempFile(t, "flag.yml", `
+name: app
+port: 8080
+tags:
+  - a
+  - b
+timeout: 3s
+remote:
+  host: localhost
+`)
+	defer removeTempFile(path)
+
+	var flags configFlags
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.ParseConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "-p", "9090", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "app" || flags.Port != 9090 || !reflect.DeepEqual(flags.Tags, []string{"a", "b"}) ||
+		flags.Timeout != 3*time.Second || flags.Remote.Host != "localhost" {
+		t.Fatal("config values should be applied", flags)
+	}
+
+	path = writeTempFile(t, "flag.yaml", "port: a")
+	defer removeTempFile(path)
+	err = set.ParseConfigFile(path)
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("invalid config should be reported", err)
+	}
+	err = set.ParseConfigFile("flag.ini")
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("unsupported config format should be reported", err)
+	}
+}
+
+func TestParseWith(t *testing.T) {
+	path := writeTempFile(t, "flag.json", `{"name": "config", "port": 8080, "debug": true}`)
+	defer removeTempFile(path)
+	os.Setenv("FLAG_TEST_PARSEWITH_NAME", "env")
+	os.Setenv("FLAG_TEST_PARSEWITH_PORT", "7070")
+	defer os.Unsetenv("FLAG_TEST_PARSEWITH_NAME")
+	defer os.Unsetenv("FLAG_TEST_PARSEWITH_PORT")
+
+	var flags struct {
+		Name  string `names:"--name" env:"FLAG_TEST_PARSEWITH_NAME"`
+		Port  int    `names:"-p, --port" env:"FLAG_TEST_PARSEWITH_PORT" default:"80"`
+		Debug bool   `names:"--debug"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = set.ParseWith(ConfigFileSource(path), EnvSource(), ArgsSource("test", "--name", "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "args" || flags.Port != 7070 || !flags.Debug {
+		t.Fatal("later sources should override earlier ones", flags.Name, flags.Port, flags.Debug)
+	}
+
+	set.Reset()
+	err = set.ParseWith(ArgsSource("test", "--name", "args", "-p", "9090"), EnvSource(), ConfigFileSource(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "config" || flags.Port != 8080 || !flags.Debug {
+		t.Fatal("config file should override command line and environment", flags.Name, flags.Port, flags.Debug)
+	}
+
+	set.Reset()
+	err = set.ParseWith(ArgsSource("test", "--name", "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "args" || flags.Port != 80 || flags.Debug {
+		t.Fatal("unlisted sources should not be used", flags.Name, flags.Port, flags.Debug)
+	}
+
+	set.Reset()
+	err = set.ParseWith(EnvSource())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "env" || flags.Port != 7070 {
+		t.Fatal("environment should be used without command line", flags.Name, flags.Port)
+	}
+}
+
+func TestNargs(t *testing.T) {
+	var flags struct {
+		Size  []int    `names:"--size" nargs:"2"`
+		Point []string `names:"-p" nargs:"3"`
+		Files []string `args:"true"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "--size", "1024", "768", "-p=a", "b", "c", "-p", "d", "e", "f", "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Size, []int{1024, 768}) ||
+		!reflect.DeepEqual(flags.Point, []string{"a", "b", "c", "d", "e", "f"}) ||
+		!reflect.DeepEqual(flags.Files, []string{"a.go"}) {
+		t.Fatal("nargs values should be consumed", flags.Size, flags.Point, flags.Files)
+	}
+
+	set.Reset()
+	for _, args := range [][]string{
+		{"test", "--size", "1024"},
+		{"test", "--size", "1024", "-p", "a", "b", "c"},
+	} {
+		err = set.Parse(args...)
+		if err == nil || err.(flagError).Type != errFlagValueNotProvided {
+			t.Fatal("insufficient values should be reported", args, err)
+		}
+		set.Reset()
+	}
+
+	var invalid struct {
+		Size int `nargs:"2"`
+	}
+	err = NewFlagSet(Flag{Names: "test"}).ErrHandling(0).StructFlags(&invalid)
+	if err == nil || err.(flagError).Type != errInvalidType {
+		t.Fatal("nargs of non-slice flag should be reported", err)
+	}
+}
+
+func TestEnableFlag(t *testing.T) {
+	var flags struct {
+		Verbose bool `names:"-v"`
+		TLS     struct {
+			Enable bool   `names:"--with-tls"`
+			Cert   string `names:"--cert" default:"cert.pem"`
+		} `names:"tls"`
+		Files []string `args:"true"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "--with-tls", "--cert", "a.pem", "-v", "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flags.TLS.Enable || flags.TLS.Cert != "a.pem" || !flags.Verbose || !reflect.DeepEqual(flags.Files, []string{"a.go"}) {
+		t.Fatal("subset should be enabled by flag", flags.TLS.Enable, flags.TLS.Cert, flags.Verbose, flags.Files)
+	}
+
+	set.Reset()
+	err = set.Parse("test", "--with-tls", "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flags.TLS.Enable || flags.TLS.Cert != "cert.pem" || !reflect.DeepEqual(flags.Files, []string{"a.go"}) {
+		t.Fatal("default value of enabled subset should be applied", flags.TLS.Enable, flags.TLS.Cert, flags.Files)
+	}
+
+	set.Reset()
+	err = set.Parse("test", "tls", "--cert", "b.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flags.TLS.Enable || flags.TLS.Cert != "b.pem" {
+		t.Fatal("subset name should still work", flags.TLS.Enable, flags.TLS.Cert)
+	}
+
+	var invalid struct {
+		V   bool `names:"--with-tls"`
+		TLS struct {
+			Enable bool `names:"--with-tls"`
+		}
+	}
+	err = NewFlagSet(Flag{Names: "test"}).ErrHandling(0).StructFlags(&invalid)
+	if err == nil || err.(flagError).Type != errDuplicateFlagRegister {
+		t.Fatal("duplicate enable flag should be reported", err)
+	}
+}
+
+func TestFlagAccessors(t *testing.T) {
+	var flags struct {
+		Name  string  `names:"--name" required:"true"`
+		Level string  `names:"--level" default:"info" selects:"debug,info,warn"`
+		Ratio float64 `names:"--ratio" selects:"0.5,1"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := set.FindFlag("--name")
+	level, _ := set.FindFlag("--level")
+	ratio, _ := set.FindFlag("--ratio")
+	if !name.IsRequired() || name.HasDefault() || name.DefaultValue() != nil || name.AllowedValues() != nil {
+		t.Fatal("accessors of required flag are incorrect")
+	}
+	if level.IsRequired() || !level.HasDefault() || level.DefaultValue() != "info" ||
+		!reflect.DeepEqual(level.AllowedValues(), []string{"debug", "info", "warn"}) {
+		t.Fatal("accessors of optional flag are incorrect", level.DefaultValue(), level.AllowedValues())
+	}
+	if !reflect.DeepEqual(ratio.AllowedValues(), []string{"0.5", "1"}) {
+		t.Fatal("number selects should be formatted", ratio.AllowedValues())
+	}
+
+	err = set.Parse("test", "--level", "warn")
+	if err == nil || err.(flagError).Type != errFlagValueNotProvided {
+		t.Fatal("missing required flag should be reported", err)
+	}
+	set.Reset()
+	err = set.Parse("test", "--name", "app")
+	if err != nil || flags.Name != "app" {
+		t.Fatal("required flag should be accepted", err, flags.Name)
+	}
+	if !strings.Contains(set.ToString(0), "required") {
+		t.Fatal("required flag should be shown in help")
+	}
+}
+
+func TestConfigNull(t *testing.T) {
+	path := writeTempFile(t, "flag.yml", `
+debug:
+name:
+port: ~
+`)
+	defer removeTempFile(path)
+
+	var flags configFlags
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.ParseConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flags.Debug || flags.Name != "" || flags.Port != 80 {
+		t.Fatal("null value should imply true for bool flag and be ignored for others", flags.Debug, flags.Name, flags.Port)
+	}
+}
+
+func TestAllowExtraArgs(t *testing.T) {
+	var flags struct {
+		Verbose bool `names:"-v"`
+		Sub     struct {
+			Enable bool
+			Name   string `names:"--name"`
+		}
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "sub", "a.go", "--name", "n", "b.go")
+	if err == nil || err.(flagError).Type != errNonFlagValue ||
+		!strings.Contains(err.Error(), "test.sub") || !strings.Contains(err.Error(), "2 extra values: a.go b.go") {
+		t.Fatal("extra values should be reported with count and command path", err)
+	}
+
+	set.Reset()
+	set.AllowExtraArgs(true)
+	err = set.Parse("test", "-v", "sub", "a.go", "--name", "n", "b.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flags.Verbose || !flags.Sub.Enable || flags.Sub.Name != "n" {
+		t.Fatal("extra values should be ignored", flags.Verbose, flags.Sub.Enable, flags.Sub.Name)
+	}
+}
+
+func TestHiddenAlias(t *testing.T) {
+	var flags struct {
+		Out string `names:"-o, --out, ~--output" usage:"output file"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "--output", "a.out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Out != "a.out" {
+		t.Fatal("hidden alias should work", flags.Out)
+	}
+	help := set.ToString(0)
+	if !strings.Contains(help, "-o, --out") || strings.Contains(help, "--output") {
+		t.Fatal("hidden alias should not be shown in help", help)
+	}
+
+	var invalid struct {
+		Out string `names:"~--output"`
+	}
+	err = NewFlagSet(Flag{Names: "test"}).ErrHandling(0).StructFlags(&invalid)
+	if err == nil || err.(flagError).Type != errInvalidNames {
+		t.Fatal("flag with only hidden names should be reported", err)
+	}
+}
+
+func TestAttachedSliceSplit(t *testing.T) {
+	var flags struct {
+		Tags  []string `names:"--tags"`
+		Ports []int    `names:"-p"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "--tags=a,b,c", "--tags", "d,e", "--tags=f\\,g", "-p=80,443", "-p", "8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Tags, []string{"a", "b", "c", "d,e", "f,g"}) ||
+		!reflect.DeepEqual(flags.Ports, []int{80, 443, 8080}) {
+		t.Fatal("attached value of slice flag should be splitted", flags.Tags, flags.Ports)
+	}
+}
+
+func TestHelpWidth(t *testing.T) {
+	var flags struct {
+		Out string `names:"-o" usage:"output file" desc:"the output file, it will be created if not exists, otherwise it's truncated\n    keep this long pre-indented line as it is without any wrapping"`
+	}
+	set := NewFlagSet(Flag{Names: "test", Desc: "test is a tool used for testing the wrapping of help message\n```\nfenced code block line is not wrapped too\n```"}).
+		ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.HelpWidth(40)
+	help := set.ToString(0)
+	expect := "Usage: test [FLAG]...\n\n" +
+		"Description:\n" +
+		"    test is a tool used for testing the\n" +
+		"    wrapping of help message\n" +
+		"    ```\n" +
+		"    fenced code block line is not wrapped too\n" +
+		"    ```\n\n" +
+		"Flags:\n" +
+		"    -o    output file    (type: string)\n" +
+		"          the output file, it will be\n" +
+		"          created if not exists,\n" +
+		"          otherwise it's truncated\n" +
+		"              keep this long pre-indented line as it is without any wrapping\n"
+	if help != expect {
+		t.Fatalf("help message should be wrapped:\n%s\n%s", help, expect)
+	}
+
+	set.HelpWidth(-1)
+	if help := set.ToString(0); !strings.Contains(help, "the output file, it will be created if not exists, otherwise it's truncated") {
+		t.Fatal("help message should not be wrapped", help)
+	}
+
+	var usageFlags struct {
+		Level int `names:"-l" usage:"compression level, higher level is slower but produces smaller output"`
+	}
+	set = NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err = set.StructFlags(&usageFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.HelpWidth(40)
+	help = set.ToString(0)
+	expect = "Usage: test [FLAG]...\n\n" +
+		"Flags:\n" +
+		"    -l    compression level, higher    (type: int)\n" +
+		"          level is slower but produces\n" +
+		"          smaller output\n"
+	if help != expect {
+		t.Fatalf("usage of flag should be wrapped:\n%s\n%s", help, expect)
+	}
+}
+
+func TestExpandArgFiles(t *testing.T) {
+	inner := writeTempFile(t, "inner.args", "-v\nb.go")
+	defer removeTempFile(inner)
+	outer := writeTempFile(t, "outer.args", "--name app\n  @"+inner+"  c.go\n")
+	defer removeTempFile(outer)
+
+	var flags struct {
+		Name    string   `names:"--name"`
+		Verbose bool     `names:"-v"`
+		Files   []string `args:"true"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0).ExpandArgFiles(true)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "@"+outer, "a.go", "--", "@d.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "app" || !flags.Verbose || !reflect.DeepEqual(flags.Files, []string{"b.go", "c.go", "a.go", "@d.go"}) {
+		t.Fatal("argument files should be expanded", flags.Name, flags.Verbose, flags.Files)
+	}
+
+	cyclic := writeTempFile(t, "cyclic.args", "")
+	defer removeTempFile(cyclic)
+	err = ioutil.WriteFile(cyclic, []byte("-v @"+cyclic), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.Reset()
+	err = set.Parse("test", "@"+cyclic)
+	if err == nil || !strings.Contains(err.Error(), "cyclic argument file") {
+		t.Fatal("cyclic argument file should be reported", err)
+	}
+	set.Reset()
+	err = set.Parse("test", "@"+cyclic+".notexist")
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("missing argument file should be reported", err)
+	}
+}
+
+func TestMarshalValues(t *testing.T) {
+	var flags struct {
+		Name    string        `names:"-n, --name"`
+		Port    *int          `names:"--port"`
+		Level   *string       `names:"--level"`
+		Tags    []string      `names:"--tags"`
+		Timeout time.Duration `names:"--timeout" default:"3s"`
+		Remote  struct {
+			Enable bool
+			Host   string `names:"--host" default:"localhost"`
+		}
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0).ExitOnHelp(false)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "-n", "app", "--port", "80", "--tags", "a", "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := set.MarshalValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"name":"app","port":80,"remote":{"host":"localhost"},"tags":["a"],"timeout":"3s"}`
+	if string(content) != expect {
+		t.Fatal("values should be marshaled", string(content))
+	}
+}
+
+func TestClearable(t *testing.T) {
+	var flags struct {
+		Tags  []string `names:"--tags" default:"a,b" clearable:"true"`
+		Names []string `names:"--names" default:"a,b"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "--tags", "", "--names", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Tags == nil || len(flags.Tags) != 0 || !reflect.DeepEqual(flags.Names, []string{""}) {
+		t.Fatal("empty value should clear clearable slice only", flags.Tags, flags.Names)
+	}
+
+	set.Reset()
+	err = set.Parse("test", "--tags", "x", "--tags=", "--tags", "y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Tags, []string{"y"}) {
+		t.Fatal("values before empty value should be cleared", flags.Tags)
+	}
+
+	var invalid struct {
+		Name string `clearable:"true"`
+	}
+	err = NewFlagSet(Flag{Names: "test"}).ErrHandling(0).StructFlags(&invalid)
+	if err == nil || err.(flagError).Type != errInvalidType {
+		t.Fatal("clearable non-slice flag should be reported", err)
+	}
+}
+
+func TestBoolValue(t *testing.T) {
+	var flags struct {
+		Verbose bool     `names:"--v" boolvalue:"true"`
+		Color   bool     `names:"--color"`
+		Files   []string `args:"true"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		Args    []string
+		Verbose bool
+		Files   []string
+	}{
+		{[]string{"test", "--v"}, true, nil},
+		{[]string{"test", "--v", "true", "a.go"}, true, []string{"a.go"}},
+		{[]string{"test", "--v", "FALSE", "a.go"}, false, []string{"a.go"}},
+		{[]string{"test", "--v", "somefile"}, true, []string{"somefile"}},
+		{[]string{"test", "--v", "1"}, true, []string{"1"}},
+		{[]string{"test", "--color", "false"}, false, []string{"false"}},
+	} {
+		set.Reset()
+		err = set.Parse(c.Args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flags.Verbose != c.Verbose || !reflect.DeepEqual(flags.Files, c.Files) {
+			t.Fatal("bool value should be consumed only if it's bool literal", c.Args, flags.Verbose, flags.Files)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	var flags struct {
+		Verbose bool `names:"-v"`
+		Remote  struct {
+			Enable bool
+			Add    struct {
+				Enable bool
+				Name   string `names:"-n, --name"`
+			} `names:"add, a" usage:"add remote"`
+			Remove struct {
+				Enable bool
+			} `names:"remove" usage:"remove remote"`
+		} `usage:"manage remotes"`
+		Push struct {
+			Enable bool
+			Force  bool `names:"-f"`
+		}
+	}
+	set := NewFlagSet(Flag{Names: "git", Usage: "version control"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []CommandInfo{
+		{Path: []string{"git"}, Usage: "version control", Flags: []string{"-v"}},
+		{Path: []string{"git", "remote"}, Usage: "manage remotes", Flags: []string{}},
+		{Path: []string{"git", "remote", "add"}, Usage: "add remote", Flags: []string{"-n, --name"}},
+		{Path: []string{"git", "remote", "remove"}, Usage: "remove remote", Flags: []string{}},
+		{Path: []string{"git", "push"}, Flags: []string{"-f"}},
+	}
+	if cmds := set.Commands(); !reflect.DeepEqual(cmds, expect) {
+		t.Fatal("commands should be listed in declaration order", cmds)
+	}
+}
+
+func TestEnvOnly(t *testing.T) {
+	os.Setenv("FLAG_TEST_ENVONLY_TOKEN", "secret")
+	defer os.Unsetenv("FLAG_TEST_ENVONLY_TOKEN")
+
+	var flags struct {
+		Token string `names:"--token" env:"FLAG_TEST_ENVONLY_TOKEN" envonly:"true"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Token != "secret" {
+		t.Fatal("env-only flag should be set by environment", flags.Token)
+	}
+	set.Reset()
+	err = set.Parse("test", "--token", "leaked")
+	if err == nil || err.(flagError).Type != errFlagNotFound {
+		t.Fatal("env-only flag should be refused on command line", err)
+	}
+	if !strings.Contains(set.ToString(0), "env only") {
+		t.Fatal("env-only flag should be noted in help")
+	}
+
+	var invalid struct {
+		Token string `envonly:"true"`
+	}
+	err = NewFlagSet(Flag{Names: "test"}).ErrHandling(0).StructFlags(&invalid)
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("env-only flag without environment name should be reported", err)
+	}
+}
+
+func TestSecret(t *testing.T) {
+	var flags struct {
+		Token string `names:"--token" default:"default-token" secret:"true"`
+		Name  string `names:"--name"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "--token", "real-token", "--name", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Token != "real-token" {
+		t.Fatal("secret value should be kept in pointer", flags.Token)
+	}
+
+	var buf strings.Builder
+	err = set.DumpValues(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := set.MarshalValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := []string{set.ToString(0), buf.String(), string(content)}
+	set.SetHelpTemplate(DefaultHelpTemplate)
+	outputs = append(outputs, set.ToString(0))
+	for _, output := range outputs {
+		if strings.Contains(output, "real-token") || strings.Contains(output, "default-token") ||
+			!strings.Contains(output, "***") {
+			t.Fatal("secret value should be masked", output)
+		}
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls int
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	for _, name := range []string{"-a", "-b"} {
+		err := set.Flag(Flag{
+			Names: name,
+			Ptr:   new(string),
+			DefaultFunc: func() interface{} {
+				calls++
+				cancel()
+				return "slow"
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := set.ParseContext(ctx, "test")
+	if err != context.Canceled || calls != 1 {
+		t.Fatal("parsing should be aborted by context", err, calls)
+	}
+
+	set.Reset()
+	err = set.ParseContext(context.Background(), "test")
+	if err != nil || calls != 3 {
+		t.Fatal("parsing should succeed without cancellation", err, calls)
+	}
+}
+
+func TestRune(t *testing.T) {
+	var flags struct {
+		Delimiter rune    `names:"--delimiter" rune:"true" default:","`
+		Quote     rune    `names:"--quote" rune:"true" selects:"',\""`
+		Escapes   []int32 `names:"--escapes" rune:"true" default:"\\,,|"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "--quote", "\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Delimiter != ',' || flags.Quote != '"' || !reflect.DeepEqual(flags.Escapes, []int32{',', '|'}) {
+		t.Fatal("rune values should be parsed", flags.Delimiter, flags.Quote, flags.Escapes)
+	}
+	if help := set.ToString(0); !strings.Contains(help, "type: rune; default: ,") {
+		t.Fatal("rune flag should be displayed as rune", help)
+	}
+
+	set.Reset()
+	err = set.Parse("test", "--delimiter", "ab")
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("multiple characters should be reported", err)
+	}
+	set.Reset()
+	err = set.Parse("test", "--quote", "`")
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("rune value not in selects should be reported", err)
+	}
+
+	var invalid struct {
+		Delimiter string `rune:"true"`
+	}
+	err = NewFlagSet(Flag{Names: "test"}).ErrHandling(0).StructFlags(&invalid)
+	if err == nil || err.(flagError).Type != errInvalidType {
+		t.Fatal("rune tag of non-int32 flag should be reported", err)
+	}
+}
+
+type testFormat string
+
+const (
+	testFormatJSON testFormat = "json"
+	testFormatYAML testFormat = "yaml"
+)
+
+func TestNamedString(t *testing.T) {
+	var flags struct {
+		Format  testFormat   `names:"--format" default:"json" selects:"json,yaml"`
+		Formats []testFormat `names:"--formats" selects:"json,yaml"`
+		Output  *testFormat  `names:"--output"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "--formats", "yaml", "--formats=json", "--output", "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Format != testFormatJSON || !reflect.DeepEqual(flags.Formats, []testFormat{testFormatYAML, testFormatJSON}) ||
+		flags.Output == nil || *flags.Output != "text" {
+		t.Fatal("named string values should be parsed", flags.Format, flags.Formats, flags.Output)
+	}
+	if help := set.ToString(0); !strings.Contains(help, "type: string; default: json; selects: [json yaml]") {
+		t.Fatal("named string flag should be displayed", help)
+	}
+
+	set.Reset()
+	err = set.Parse("test", "--format", "xml")
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("value not in selects should be reported", err)
+	}
+}
+
+type testLevel uint8
+
+func (l testLevel) String() string {
+	return [...]string{"debug", "info", "warn"}[l]
+}
+
+type testRatio float64
+
+type testSwitch bool
+
+func TestNamedTypes(t *testing.T) {
+	var flags struct {
+		Level  testLevel   `names:"--level" default:"1" selects:"0,1,2"`
+		Ratios []testRatio `names:"--ratio" default:"0.5"`
+		Switch testSwitch  `names:"-s"`
+		Offset *testLevel  `names:"--offset"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Parse("test", "-s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Level != 1 || !reflect.DeepEqual(flags.Ratios, []testRatio{0.5}) || flags.Switch != true || flags.Offset != nil {
+		t.Fatal("default values of named types should be applied", flags.Level, flags.Ratios, flags.Switch, flags.Offset)
+	}
+	if help := set.ToString(0); !strings.Contains(help, "type: uint8; default: 1; selects: [0 1 2]") {
+		t.Fatal("named type should be displayed as base type", help)
+	}
+
+	set.Reset()
+	err = set.Parse("test", "--level", "2", "--ratio", "1.5", "--ratio", "2", "--offset", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Level != 2 || !reflect.DeepEqual(flags.Ratios, []testRatio{1.5, 2}) || flags.Switch != false ||
+		flags.Offset == nil || *flags.Offset != 1 {
+		t.Fatal("named types should be parsed", flags.Level, flags.Ratios, flags.Switch, flags.Offset)
+	}
+
+	set.Reset()
+	err = set.Parse("test", "--level", "3")
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("value not in selects should be reported", err)
+	}
+}
+
+func TestMutuallyExclusive(t *testing.T) {
+	var flags struct {
+		GZ      bool `names:"-z, --gz"`
+		BZ      bool `names:"-j, --bz"`
+		XZ      bool `names:"-J, --xz"`
+		Create  bool `names:"-c"`
+		Extract bool `names:"-x"`
+		Verbose bool `names:"-v"`
+	}
+	set := NewFlagSet(Flag{Names: "tar"}).ErrHandling(0)
+	err := set.StructFlags(&flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = set.MutuallyExclusive("gz", "bz", "xz"); err != nil {
+		t.Fatal(err)
+	}
+	if err = set.RequireOneOf("-c", "-x"); err != nil {
+		t.Fatal(err)
+	}
+	if line := set.UsageLine(); line != "Usage: tar [FLAG]... [-z | -j | -J] (-c | -x)" {
+		t.Fatal("exclusive groups should be shown in synopsis", line)
+	}
+
+	for _, c := range []struct {
+		Args    []string
+		ErrType errorType
+	}{
+		{Args: []string{"tar", "-c"}},
+		{Args: []string{"tar", "-x", "-z"}},
+		{Args: []string{"tar", "-c", "-z", "-J"}, ErrType: errFlagConflict},
+		{Args: []string{"tar", "-z"}, ErrType: errFlagDependency},
+	} {
+		set.Reset()
+		err = set.Parse(c.Args...)
+		if c.ErrType == 0 {
+			if err != nil {
+				t.Fatal(c.Args, err)
+			}
+			continue
+		}
+		if err == nil || err.(flagError).Type != c.ErrType {
+			t.Fatal("exclusive group should be checked", c.Args, err)
+		}
+	}
+}
+
+func TestUsagePlaceholder(t *testing.T) {
+	type Flags struct {
+		Output string "names:\"-o\" usage:\"set the output `file`\""
+		Input  string "names:\"-i\" arglist:\"path\" usage:\"read `input` file\""
+		Level  int    `names:"-l" usage:"compression level"`
+	}
+	var flags Flags
+
+	set := NewFlagSet(Flag{Names: "zip"})
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name, arglist, usage string
+	}{
+		{"-o", "file", "set the output file"},
+		{"-i", "path", "read input file"},
+		{"-l", "", "compression level"},
+	} {
+		flag, err := set.FindFlag(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flag.Arglist != c.arglist || flag.Usage != c.usage {
+			t.Fatal("usage placeholder test failed", c.name, flag.Arglist, flag.Usage)
+		}
+	}
+	if help := set.ToString(0); !strings.Contains(help, "-o file") {
+		t.Fatal("placeholder should be shown in help", help)
+	}
+
+	if err := set.UpdateMeta("-l", Flag{Usage: "compression `level`"}); err != nil {
+		t.Fatal(err)
+	}
+	if flag, _ := set.FindFlag("-l"); flag.Arglist != "level" || flag.Usage != "compression level" {
+		t.Fatal("usage placeholder of meta test failed", flag.Arglist, flag.Usage)
+	}
+}
+
+func TestExitCodeFor(t *testing.T) {
+	type Flags struct {
+		Port int `names:"--port"`
+		Sub  struct {
+			Enable bool
+			Name   string `names:"--name"`
+		}
+	}
+	var flags Flags
+
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.ExitCodeFor("FlagNotFound", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.ExitCodeFor("InvalidValue", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.ExitCodeFor("FlagNotFond", 5); err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("unknown error type should be rejected", err)
+	}
+	for _, c := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"test", "--host"}, 3},
+		{[]string{"test", "--port", "a"}, 4},
+		{[]string{"test", "sub", "--none"}, 3},
+		{[]string{"test", "--port"}, 2},
+	} {
+		err := set.Parse(c.args...)
+		if err == nil {
+			t.Fatal("parse should fail", c.args)
+		}
+		if code := set.exitCode(err); code != c.code {
+			t.Fatal("exit code test failed", c.args, err, code)
+		}
+	}
+	sub, _ := set.FindSubset("sub")
+	if sub.exitCode(newErrorf(errFlagNotFound, "")) != 3 || sub.exitCode(newErrorf(errInvalidValue, "")) != 4 {
+		t.Fatal("exit codes should be inherited by subset")
+	}
+}
+
+func TestStringSet(t *testing.T) {
+	type Flags struct {
+		Features map[string]bool `names:"--enable" default:"featureA"`
+		Disable  map[string]bool `names:"--disable" env:"TEST_DISABLED_FEATURES"`
+		Selected map[string]bool `names:"--selected" selects:"x,y"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	os.Setenv("TEST_DISABLED_FEATURES", "c,d")
+	defer os.Unsetenv("TEST_DISABLED_FEATURES")
+	err := set.ParseStruct(&flags, "test", "--disable", "e", "--selected", "x", "--selected=y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Features, map[string]bool{"featureA": true}) ||
+		!reflect.DeepEqual(flags.Disable, map[string]bool{"e": true}) ||
+		!reflect.DeepEqual(flags.Selected, map[string]bool{"x": true, "y": true}) {
+		t.Fatal("string set test failed", flags)
+	}
+
+	flags = Flags{}
+	err = set.Parse("test", "--enable", "featureB", "--enable=featureC,featureD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Features, map[string]bool{"featureB": true, "featureC": true, "featureD": true}) ||
+		!reflect.DeepEqual(flags.Disable, map[string]bool{"c": true, "d": true}) {
+		t.Fatal("string set test failed", flags)
+	}
+
+	if err = set.Parse("test", "--selected", "z"); err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("string set selects test failed", err)
+	}
+	if flag, _ := set.FindFlag("--enable"); flagTypeName(flag) != "map[string]bool" || formatDefault(flag) != "[featureA]" {
+		t.Fatal("string set help test failed", flagTypeName(flag), formatDefault(flag))
+	}
+}
+
+func TestScopedHelpFlag(t *testing.T) {
+	type Git struct {
+		Remote struct {
+			Enable bool
+			Add    struct {
+				Enable bool
+				Fetch  bool `names:"-f" usage:"fetch after add"`
+			} `usage:"add remote"`
+		} `usage:"manage remotes"`
+	}
+	var g Git
+
+	for _, c := range []struct {
+		args     []string
+		err      error
+		children string
+	}{
+		{[]string{"git", "--help=remote.add"}, ErrHelp, "remote.add"},
+		{[]string{"git", "remote", "--help=add"}, ErrHelp, "add"},
+		{[]string{"git", "--help=true"}, ErrHelp, ""},
+		{[]string{"git", "--help=false"}, nil, ""},
+	} {
+		set := NewFlagSet(Flag{Names: "git"}).ErrHandling(0).ExitOnHelp(false)
+		if err := set.ParseStruct(&g, c.args...); err != c.err {
+			t.Fatal("scoped help test failed", c.args, err)
+		}
+		if set.help.children != c.children {
+			t.Fatal("scoped help test failed", c.args, set.help.children)
+		}
+	}
+
+	set := NewFlagSet(Flag{Names: "git"}).ErrHandling(0).ExitOnHelp(false)
+	err := set.ParseStruct(&g, "git", "--help=remote.delete")
+	if err == nil || err.(flagError).Type != errFlagNotFound {
+		t.Fatal("help for unknown subcommand should fail", err)
+	}
+}
+
+func TestFloatTolerance(t *testing.T) {
+	var (
+		ratio float64
+		level int
+	)
+	newSet := func() *FlagSet {
+		set := NewFlagSet(Flag{}).ErrHandling(0)
+		set.Flag(Flag{Names: "--ratio", Ptr: &ratio, Selects: []float32{0.1, 0.5}})
+		set.Flag(Flag{Names: "--level", Ptr: &level, Selects: []int{1, 2}})
+		return set
+	}
+
+	err := newSet().Parse("test", "--ratio", "0.1")
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("float selects should be compared exactly by default", err)
+	}
+	set := newSet().FloatTolerance(1e-6)
+	if err = set.Parse("test", "--ratio", "0.1", "--level", "2"); err != nil || ratio != 0.1 || level != 2 {
+		t.Fatal("float tolerance test failed", err, ratio, level)
+	}
+	for _, args := range [][]string{{"--ratio", "0.2"}, {"--level", "1.0000001"}} {
+		err = set.Parse(append([]string{"test"}, args...)...)
+		if err == nil || err.(flagError).Type != errInvalidValue {
+			t.Fatal("value out of tolerance should be invalid", args, err)
+		}
+	}
+}
+
+func TestIgnoreUnknown(t *testing.T) {
+	type Flags struct {
+		Verbose bool   `names:"-v"`
+		Name    string `names:"--name"`
+		Run     struct {
+			Enable bool
+			Image  string `names:"--image"`
+		}
+	}
+	var flags Flags
+
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&flags, "test", "-v", "--color=auto", "run", "--image", "alpine", "--rm", "sh", "-c")
+	if err == nil || err.(flagError).Type != errFlagNotFound {
+		t.Fatal("unknown flag should be reported by default", err)
+	}
+
+	set = NewFlagSet(Flag{}).ErrHandling(0).IgnoreUnknown(true)
+	err = set.ParseStruct(&flags, "test", "-v", "--color=auto", "--name", "a", "run", "--image", "alpine", "--rm", "sh", "-c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flags.Verbose || flags.Name != "a" || flags.Run.Image != "alpine" {
+		t.Fatal("known flags should be applied", flags)
+	}
+	unknownFlags, unknownArgs := set.Unknown()
+	if !reflect.DeepEqual(unknownFlags, []string{"--color=auto", "--rm", "-c"}) || !reflect.DeepEqual(unknownArgs, []string{"sh"}) {
+		t.Fatal("unknown test failed", unknownFlags, unknownArgs)
+	}
+}
+
+func TestDefaultValSep(t *testing.T) {
+	type Flags struct {
+		Paths []string `names:"--path" default:"/bin:/usr/bin"`
+		Tags  []string `names:"--tag" env:"TEST_VALSEP_TAGS"`
+		Ports []int    `names:"--port" valsep:"," default:"80,443"`
+		Run   struct {
+			Enable bool
+			Mounts []string `names:"--mount"`
+			Args   []string `args:"true" argsdefault:"a:b"`
+		}
+	}
+	var flags Flags
+
+	os.Setenv("TEST_VALSEP_TAGS", "x:y")
+	defer os.Unsetenv("TEST_VALSEP_TAGS")
+	set := NewFlagSet(Flag{}).ErrHandling(0).DefaultValSep(":")
+	err := set.ParseStruct(&flags, "test", "run", "--mount=/a:/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Paths, []string{"/bin", "/usr/bin"}) || !reflect.DeepEqual(flags.Tags, []string{"x", "y"}) ||
+		!reflect.DeepEqual(flags.Ports, []int{80, 443}) {
+		t.Fatal("default valsep test failed", flags)
+	}
+	if !reflect.DeepEqual(flags.Run.Mounts, []string{"/a", "/b"}) || !reflect.DeepEqual(flags.Run.Args, []string{"a", "b"}) {
+		t.Fatal("default valsep of subset test failed", flags.Run)
+	}
+}
+
+func TestNulValSep(t *testing.T) {
+	type Flags struct {
+		Excludes []string `names:"--exclude" env:"TEST_NUL_EXCLUDES" valsep:"\\0"`
+		Files    []string `args:"true" env:"TEST_NUL_FILES" valsep:"nul"`
+	}
+	var flags Flags
+
+	// NUL byte is not allowed in real environment variables, values come from stdin or files usually
+	values := map[string]string{
+		"TEST_NUL_EXCLUDES": " a b\x00c\\d",
+		"TEST_NUL_FILES":    "x.go\x00my file.go\x00",
+	}
+	envParser = func(name string) string {
+		return values[name]
+	}
+	defer func() {
+		envParser = os.Getenv
+	}()
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.ParseStruct(&flags, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Excludes, []string{" a b", "c\\d"}) || !reflect.DeepEqual(flags.Files, []string{"x.go", "my file.go"}) {
+		t.Fatalf("nul valsep test failed: %q %q", flags.Excludes, flags.Files)
+	}
+	if help := set.ToString(0); !strings.Contains(help, "splitted by NUL") {
+		t.Fatal("nul separator should be shown in help", help)
+	}
+}
+
+func TestInvert(t *testing.T) {
+	type Flags struct {
+		EnableCache bool `names:"--disable-cache" invert:"true" env:"TEST_DISABLE_CACHE"`
+		Verbose     bool `names:"-v"`
+	}
+
+	for _, c := range []struct {
+		args   []string
+		env    string
+		init   bool
+		expect bool
+	}{
+		{[]string{"test"}, "", true, true},
+		{[]string{"test", "--disable-cache"}, "", true, false},
+		{[]string{"test", "--disable-cache=false"}, "", false, true},
+		{[]string{"test"}, "true", true, false},
+		{[]string{"test", "--disable-cache=no"}, "yes", false, true},
+	} {
+		os.Setenv("TEST_DISABLE_CACHE", c.env)
+		flags := Flags{EnableCache: c.init}
+		if err := NewFlagSet(Flag{}).ErrHandling(0).ParseStruct(&flags, c.args...); err != nil {
+			t.Fatal(err)
+		}
+		if flags.EnableCache != c.expect {
+			t.Fatal("invert test failed", c.args, c.env, flags.EnableCache)
+		}
+	}
+	os.Unsetenv("TEST_DISABLE_CACHE")
+
+	flags := Flags{EnableCache: true}
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.ParseStruct(&flags, "test", "--disable-cache"); err != nil {
+		t.Fatal(err)
+	}
+	content, err := set.MarshalValues()
+	if err != nil || !strings.Contains(string(content), `"disable-cache":true`) {
+		t.Fatal("inverted value should be marshaled as flag value", string(content), err)
+	}
+	if help := set.ToString(0); !strings.Contains(help, "inverted") {
+		t.Fatal("inverted flag should be marked in help", help)
+	}
+
+	type Invalid struct {
+		Level int `names:"--level" invert:"true"`
+	}
+	err = NewFlagSet(Flag{}).ErrHandling(0).ParseStruct(&Invalid{}, "test")
+	if err == nil || err.(flagError).Type != errInvalidType {
+		t.Fatal("invert non-bool flag should fail", err)
+	}
+}
+
+func TestRawSubset(t *testing.T) {
+	type Tool struct {
+		Verbose bool `names:"-v"`
+		Exec    struct {
+			Enable bool
+			Args   []string `args:"true"`
+		} `raw:"true"`
+	}
+	var tool Tool
+
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	err := set.ParseStruct(&tool, "tool", "-v", "exec", "ls", "-v", "--", "-la", "exec", "-h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tool.Verbose || !tool.Exec.Enable || !reflect.DeepEqual(tool.Exec.Args, []string{"ls", "-v", "--", "-la", "exec", "-h"}) {
+		t.Fatal("raw subset test failed", tool)
+	}
+
+	type Invalid struct {
+		Exec struct {
+			Enable bool
+		} `raw:"true"`
+	}
+	err = NewFlagSet(Flag{}).ErrHandling(0).ParseStruct(&Invalid{}, "tool")
+	if err == nil || err.(flagError).Type != errInvalidStructure {
+		t.Fatal("raw subset without args field should fail", err)
+	}
+}
+
+func TestDenySelects(t *testing.T) {
+	type Flags struct {
+		User  string `names:"--user" denyselects:"root,admin" selectsci:"true"`
+		Port  int    `names:"--port" denyselects:"22,23"`
+		Level string `names:"--level" selects:"debug,info,warn" denyselects:"debug"`
+	}
+
+	for _, c := range []struct {
+		args  []string
+		valid bool
+	}{
+		{[]string{"--user", "alice", "--port", "80", "--level", "info"}, true},
+		{[]string{"--user", "Root"}, false},
+		{[]string{"--port", "22"}, false},
+		{[]string{"--level", "debug"}, false},
+		{[]string{"--level", "error"}, false},
+	} {
+		var flags Flags
+		err := NewFlagSet(Flag{}).ErrHandling(0).ParseStruct(&flags, append([]string{"test"}, c.args...)...)
+		if c.valid && err != nil {
+			t.Fatal(c.args, err)
+		}
+		if !c.valid && (err == nil || err.(flagError).Type != errInvalidValue) {
+			t.Fatal("denied value should be invalid", c.args, err)
+		}
+	}
+
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.StructFlags(new(Flags)); err != nil {
+		t.Fatal(err)
+	}
+	if help := set.ToString(0); !strings.Contains(help, "denied: [22 23]") {
+		t.Fatal("denied values should be shown in help", help)
+	}
+}
+
+func TestPercent(t *testing.T) {
+	type Flags struct {
+		Opacity float64   `names:"--opacity" percent:"true" default:"50%"`
+		Scale   float32   `names:"--scale" percent:"true" selects:"50%,100%,2"`
+		Ratios  []float64 `names:"--ratio" percent:"true" default:"10%,0.2"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.ParseStruct(&flags, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if flags.Opacity != 0.5 || !reflect.DeepEqual(flags.Ratios, []float64{0.1, 0.2}) {
+		t.Fatal("percent default test failed", flags)
+	}
+	flags = Flags{}
+	err := set.Parse("test", "--opacity", "0.25", "--scale", "100%", "--ratio=5%,1.5", "--ratio", "200 %")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags.Opacity != 0.25 || flags.Scale != 1 || !reflect.DeepEqual(flags.Ratios, []float64{0.05, 1.5, 2}) {
+		t.Fatal("percent test failed", flags)
+	}
+	for _, args := range [][]string{{"--scale", "75%"}, {"--opacity", "a%"}} {
+		err = set.Parse(append([]string{"test"}, args...)...)
+		if err == nil || err.(flagError).Type != errInvalidValue {
+			t.Fatal("invalid percent value should fail", args, err)
+		}
+	}
+
+	type Invalid struct {
+		Count int `names:"--count" percent:"true"`
+	}
+	err = NewFlagSet(Flag{}).ErrHandling(0).ParseStruct(&Invalid{}, "test")
+	if err == nil || err.(flagError).Type != errInvalidType {
+		t.Fatal("percent non-float flag should fail", err)
+	}
+}
+
+func TestRepeatTogether(t *testing.T) {
+	type Flags struct {
+		Servers []string `names:"--server"`
+		Ports   []int    `names:"--port"`
+		Debug   bool     `names:"--debug"`
+	}
+	var flags Flags
+
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.RepeatTogether("server", "port"); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.RepeatTogether("server", "debug"); err == nil || err.(flagError).Type != errInvalidType {
+		t.Fatal("repeat group of non-slice flag should fail", err)
+	}
+
+	err := set.Parse("test", "--server", "host1", "--port", "1", "--server", "host2", "--port", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	occurrences, err := set.Occurrences("server", "port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(occurrences, [][]string{{"host1", "1"}, {"host2", "2"}}) {
+		t.Fatal("occurrences test failed", occurrences)
+	}
+
+	for _, args := range [][]string{
+		{"--server", "host1", "--port", "1", "--server", "host2"},
+		{"--server", "host1"},
+	} {
+		set.Reset()
+		err = set.Parse(append([]string{"test"}, args...)...)
+		if err == nil || err.(flagError).Type != errFlagDependency {
+			t.Fatal("unequal repeated flags should fail", args, err)
+		}
+	}
+	set.Reset()
+	if err = set.Parse("test", "--debug"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNamingStyle(t *testing.T) {
+	type Flags struct {
+		MaxConns  int
+		HTTPAddr  string
+		V         bool
+		Output    string `names:"-o"`
+		AddRemote struct {
+			Enable bool
+		}
+	}
+
+	for _, c := range []struct {
+		style NamingStyle
+		names []string
+	}{
+		{NamingDefault, []string{"-maxConns", "-httpaddr", "-v", "-o", "addRemote"}},
+		{NamingCamel, []string{"--maxConns", "--httpAddr", "-v", "-o", "addRemote"}},
+		{NamingKebab, []string{"--max-conns", "--http-addr", "-v", "-o", "add-remote"}},
+		{NamingSnake, []string{"--max_conns", "--http_addr", "-v", "-o", "add_remote"}},
+	} {
+		set := NewFlagSet(Flag{}).ErrHandling(0).NamingStyle(c.style)
+		if err := set.StructFlags(new(Flags)); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range c.names {
+			if _, err := set.FindFlag(name); err != nil {
+				t.Fatal("naming style test failed", c.style, name, err)
+			}
+		}
+	}
+}
+
+func TestNamingStyleLongFlag(t *testing.T) {
+	type Flags struct {
+		V       bool
+		Verbose bool
+		Output  string `names:"-output"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0).NamingStyle(NamingKebab)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"-v", "--verbose", "-output"} {
+		if _, err := set.FindFlag(name); err != nil {
+			t.Fatal("long flag name test failed", name, err)
+		}
+	}
+	if _, err := set.FindFlag("-verbose"); err == nil {
+		t.Fatal("single dash should not be used for long flag name")
+	}
+	err := set.Parse("app", "-v", "--verbose", "-output", "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flags.V || !flags.Verbose || flags.Output != "a.txt" {
+		t.Fatal("long flag name parse failed", flags)
+	}
+}
+
+func TestEnvSliceGrow(t *testing.T) {
+	type Flags struct {
+		Files []string `names:"--files" env:"FLAG_TEST_ENV_FILES" valsep:" "`
+		Ports []int    `names:"--ports" env:"FLAG_TEST_ENV_PORTS"`
+	}
+	os.Setenv("FLAG_TEST_ENV_FILES", "a b c")
+	os.Setenv("FLAG_TEST_ENV_PORTS", "1,2,3,4")
+	defer os.Unsetenv("FLAG_TEST_ENV_FILES")
+	defer os.Unsetenv("FLAG_TEST_ENV_PORTS")
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse("app"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Files, []string{"a", "b", "c"}) || !reflect.DeepEqual(flags.Ports, []int{1, 2, 3, 4}) {
+		t.Fatal("env slice values failed", flags)
+	}
+	if cap(flags.Files) != 3 || cap(flags.Ports) != 4 {
+		t.Fatal("env slice should be pre-sized", cap(flags.Files), cap(flags.Ports))
+	}
+}
+
+func BenchmarkLargeEnvSlice(b *testing.B) {
+	type Flags struct {
+		Files []string `names:"--files" env:"FLAG_BENCH_ENV_FILES" valsep:" "`
+	}
+	files := make([]string, 10000)
+	for i := range files {
+		files[i] = "/path/to/file" + strconv.Itoa(i)
+	}
+	os.Setenv("FLAG_BENCH_ENV_FILES", strings.Join(files, "\n"))
+	defer os.Unsetenv("FLAG_BENCH_ENV_FILES")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var flags Flags
+		set := NewFlagSet(Flag{}).ErrHandling(0)
+		if err := set.StructFlags(&flags); err != nil {
+			b.Fatal(err)
+		}
+		if err := set.Parse("app"); err != nil {
+			b.Fatal(err)
+		}
+		if len(flags.Files) != len(files) {
+			b.Fatal("env slice values failed", len(flags.Files))
+		}
+	}
+}
+
+func BenchmarkApplyStringSlice(b *testing.B) {
+	vals := make([]string, 10000)
+	for i := range vals {
+		vals[i] = "value" + strconv.Itoa(i)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var files []string
+		flag := Flag{Names: "--files", Ptr: &files}
+		growSlice(&files, len(vals))
+		for _, val := range vals {
+			if err := applyValToPtr(&flag, val); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestStructFieldsCache(t *testing.T) {
+	type Flags struct {
+		Name  string `names:"-n" default:"def"`
+		Port  int    `flag:"names=-p;default=80"`
+		Debug bool
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var flags Flags
+			set := NewFlagSet(Flag{}).ErrHandling(0)
+			if err := set.StructFlags(&flags); err != nil {
+				errs <- err
+				return
+			}
+			port := strconv.Itoa(8000 + i)
+			if err := set.Parse("app", "-p", port, "-debug"); err != nil {
+				errs <- err
+				return
+			}
+			if flags.Name != "def" || strconv.Itoa(flags.Port) != port || !flags.Debug {
+				errs <- fmt.Errorf("cached structure registration failed: %v", flags)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	type Unknown struct {
+		Name string `names:"-n" unknown:"x"`
+	}
+	if err := NewFlagSet(Flag{}).ErrHandling(0).StructFlags(new(Unknown)); err != nil {
+		t.Fatal(err)
+	}
+	err := NewFlagSet(Flag{}).ErrHandling(0).StrictTags(true).StructFlags(new(Unknown))
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("strict tags should be checked for cached structure", err)
+	}
+}
+
+func BenchmarkStructFlags(b *testing.B) {
+	type Flags struct {
+		Name    string        `names:"-n, --name" usage:"name" default:"def"`
+		Port    int           `names:"-p, --port" selects:"80,443,8080"`
+		Hosts   []string      `names:"--hosts" env:"FLAG_BENCH_HOSTS" valsep:","`
+		Timeout time.Duration `flag:"names=--timeout;default=5s"`
+		Verbose bool          `names:"-v"`
+		Serve   struct {
+			Enable bool
+			Addr   string `names:"--addr" default:":80"`
+		} `usage:"serve"`
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var flags Flags
+		set := NewFlagSet(Flag{}).ErrHandling(0)
+		if err := set.StructFlags(&flags); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	type Flags struct {
+		Name  string   `names:"--name"`
+		Tags  []string `names:"-t"`
+		Debug bool     `names:"-d"`
+		Args  []string
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	input := "--name \"John \\\"Doe\\\"\"\n-t 'a b' -t c\\ d\n-d \\\n  file1 ''\n"
+	if err := set.ParseReader(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	expect := Flags{Name: `John "Doe"`, Tags: []string{"a b", "c d"}, Debug: true, Args: []string{"file1", ""}}
+	if !reflect.DeepEqual(flags, expect) {
+		t.Fatal("parse reader failed", flags)
+	}
+
+	err := set.ParseReader(strings.NewReader("--name 'unclosed"))
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("unclosed quote should be reported", err)
+	}
+}
+
+func TestArgsStart(t *testing.T) {
+	type Flags struct {
+		Verbose bool   `names:"-v"`
+		Output  string `names:"-o"`
+		Run     struct {
+			Enable bool
+			Name   string `names:"--name"`
+			Args   []string
+		}
+	}
+
+	for _, c := range []struct {
+		args  []string
+		start int
+	}{
+		{[]string{"app", "-v", "-o", "out", "run", "--name", "x", "a", "b"}, 7},
+		{[]string{"app", "run", "--", "-a", "b"}, 3},
+		{[]string{"app", "-o", "out", "run", "--*", "-a", "-b"}, 5},
+		{[]string{"app", "-v"}, -1},
+	} {
+		var flags Flags
+		set := NewFlagSet(Flag{}).ErrHandling(0)
+		if err := set.StructFlags(&flags); err != nil {
+			t.Fatal(err)
+		}
+		if err := set.Parse(c.args...); err != nil {
+			t.Fatal(c.args, err)
+		}
+		if set.ArgsStart() != c.start {
+			t.Fatal("args start failed", c.args, set.ArgsStart())
+		}
+		if c.start > 0 && !reflect.DeepEqual(c.args[c.start:], flags.Run.Args) {
+			t.Fatal("args should start from the index", c.args, flags.Run.Args)
+		}
+	}
+}
+
+func TestGlobalFlags(t *testing.T) {
+	type Flags struct {
+		Verbose bool     `names:"-v"`
+		Config  string   `names:"-c"`
+		Tags    []string `names:"-t" nargs:"2"`
+		Run     struct {
+			Enable bool
+			Name   string `names:"--name"`
+			Args   []string
+		}
+	}
+
+	newSet := func(flags *Flags, global bool) *FlagSet {
+		set := NewFlagSet(Flag{}).ErrHandling(0).GlobalFlags(global)
+		if err := set.StructFlags(flags); err != nil {
+			t.Fatal(err)
+		}
+		return set
+	}
+
+	var flags Flags
+	set := newSet(&flags, true)
+	err := set.Parse("app", "run", "-v", "--name", "x", "-t", "a", "b", "-c", "run.conf", "arg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := Flags{Verbose: true, Config: "run.conf", Tags: []string{"a", "b"}}
+	expect.Run.Enable, expect.Run.Name, expect.Run.Args = true, "x", []string{"arg"}
+	if !reflect.DeepEqual(flags, expect) {
+		t.Fatal("global flags failed", flags)
+	}
+	if !reflect.DeepEqual(set.ActiveSubcommand(), []string{set.self.Names, "run"}) {
+		t.Fatal("global flag should not leave subcommand", set.ActiveSubcommand())
+	}
+
+	flags = Flags{}
+	err = newSet(&flags, true).Parse("app", "-v", "run", "-v")
+	if err == nil || err.(flagError).Type != errDuplicateFlagParsed {
+		t.Fatal("global flag should be duplicated", err)
+	}
+
+	flags = Flags{}
+	err = newSet(&flags, false).Parse("app", "run", "-v", "--name", "x")
+	if err == nil {
+		t.Fatal("flag of parent should leave subcommand if global flags is disabled")
+	}
+}
+
+func TestSubcommandHelp(t *testing.T) {
+	captureStdout := func(fn func()) string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout := os.Stdout
+		os.Stdout = w
+		fn()
+		os.Stdout = stdout
+		w.Close()
+
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	type Flags struct {
+		Debug bool `names:"--debug"`
+		A     struct {
+			Enable bool
+			Name   string `names:"--name"`
+			Args   []string
+			B      struct {
+				Enable bool
+				Age    int `names:"--age"`
+			}
+		}
+	}
+
+	for _, c := range []struct {
+		args  []string
+		usage string
+	}{
+		{[]string{"tool", "-h"}, "Usage: tool"},
+		{[]string{"tool", "-h", "a"}, "Usage: tool"},
+		{[]string{"tool", "a", "-h"}, "Usage: a"},
+		{[]string{"tool", "a", "-h", "--name", "x", "file"}, "Usage: a"},
+		{[]string{"tool", "a", "-h", "b"}, "Usage: a"},
+		{[]string{"tool", "a", "b", "-h"}, "Usage: b"},
+		{[]string{"tool", "a", "b", "--help", "--age", "1"}, "Usage: b"},
+		{[]string{"tool", "a", "b", "-h", "-v", "1", "--age", "1"}, "Usage: b"},
+	} {
+		var (
+			flags Flags
+			err   error
+			set   = NewFlagSet(Flag{Names: "tool"}).ErrHandling(0).ExitOnHelp(false)
+		)
+		if err = set.StructFlags(&flags); err != nil {
+			t.Fatal(err)
+		}
+		out := captureStdout(func() {
+			err = set.Parse(c.args...)
+		})
+		if err != ErrHelp {
+			t.Fatal("help should be requested", c.args, err)
+		}
+		if !strings.HasPrefix(out, c.usage) {
+			t.Fatal("help should be scoped to subcommand", c.args, out)
+		}
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	type Flags struct {
+		Output string `names:"-o, --output" arglist:"file" usage:"output file" default:"a.out" env:"TOOL_OUT"`
+		Level  string `names:"--level" usage:"log level|verbosity" selects:"debug,info"`
+		Remote struct {
+			Enable bool
+			Add    struct {
+				Enable bool
+				Name   string `names:"--name" usage:"remote name"`
+				Args   []string
+			} `usage:"add remote"`
+		} `usage:"manage remotes"`
+	}
+
+	set := NewFlagSet(Flag{Names: "tool", Usage: "A tool"}).ErrHandling(0)
+	if err := set.StructFlags(new(Flags)); err != nil {
+		t.Fatal(err)
+	}
+	md, err := set.Markdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"# tool\n\nA tool\n\n```\ntool [FLAG|COMMAND]...\n```\n",
+		"| Name | Type | Default | Description |\n| --- | --- | --- | --- |\n",
+		"| `-o, --output file` | string | `a.out` | output file<br>(env: `TOOL_OUT`) |\n",
+		"| `--level` | string |  | log level\\|verbosity<br>(selects: `[debug info]`) |\n",
+		"Commands:\n\n* [remote](#tool-remote): manage remotes\n",
+		"## tool remote\n\nmanage remotes\n",
+		"* [add](#tool-remote-add): add remote\n",
+		"### tool remote add\n\nadd remote\n\n```\ntool remote add [FLAG]... [ARG]...\n```\n",
+		"| `--name` | string |  | remote name |\n",
+	} {
+		if !strings.Contains(md, s) {
+			t.Fatalf("markdown should contain %q:\n%s", s, md)
+		}
+	}
+}
+
+func TestEnableIf(t *testing.T) {
+	type Flags struct {
+		Name  string `names:"--name"`
+		Turbo bool   `names:"--turbo" enableif:"FLAG_TEST_EXPERIMENTAL"`
+		Labs  struct {
+			Enable bool
+			Level  int `names:"--level"`
+		} `enableif:"FLAG_TEST_EXPERIMENTAL"`
+	}
+
+	for _, c := range []struct {
+		env     string
+		enabled bool
+	}{
+		{"", false},
+		{"false", false},
+		{"invalid", false},
+		{"1", true},
+		{"yes", true},
+	} {
+		os.Setenv("FLAG_TEST_EXPERIMENTAL", c.env)
+		set := NewFlagSet(Flag{}).ErrHandling(0)
+		err := set.StructFlags(new(Flags))
+		os.Unsetenv("FLAG_TEST_EXPERIMENTAL")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ferr := set.FindFlag("--turbo")
+		_, serr := set.FindFlag("labs")
+		if (ferr == nil) != c.enabled || (serr == nil) != c.enabled {
+			t.Fatal("enableif failed", c.env, ferr, serr)
+		}
+		if help := set.ToString(0); strings.Contains(help, "--turbo") != c.enabled {
+			t.Fatal("disabled flag should not be shown in help", c.env, help)
+		}
+	}
+}
+
+func TestHelpLabels(t *testing.T) {
+	type Flags struct {
+		Output string `names:"-o" usage:"output file"`
+		Remote struct {
+			Enable bool
+			Name   string `names:"--name"`
+		} `usage:"manage remotes"`
+	}
+
+	set := NewFlagSet(Flag{Names: "tool", Version: "v1.0", Desc: "A tool"}).ErrHandling(0)
+	if err := set.StructFlags(new(Flags)); err != nil {
+		t.Fatal(err)
+	}
+	help := set.ToString(0)
+	for _, label := range []string{"Usage: tool", "Version:", "Description:", "Flags:", "Commands:"} {
+		if !strings.Contains(help, label) {
+			t.Fatal("default help label should be shown", label, help)
+		}
+	}
+
+	set.HelpLabels(HelpLabels{Usage: "用法:", Flags: "选项:", Commands: "命令:"})
+	help = set.ToString(0)
+	for _, label := range []string{"用法: tool", "Version:", "Description:", "选项:", "命令:"} {
+		if !strings.Contains(help, label) {
+			t.Fatal("help label should be overridden", label, help)
+		}
+	}
+	if strings.Contains(help, "Flags:") || !strings.HasPrefix(set.UsageLine(), "用法:") {
+		t.Fatal("help label should be overridden", help)
+	}
+	subset, err := set.FindSubset("remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(subset.ToString(0), "选项:") {
+		t.Fatal("help labels should be recursive", subset.ToString(0))
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	type Flags struct {
+		Port    uint16    `names:"-p" min:"1" max:"65535" default:"80"`
+		Ratio   float64   `names:"-r" min:"10%" max:"90%" percent:"true"`
+		Weights []int     `names:"-w" min:"0"`
+		Delay   int8      `names:"-d" max:"10"`
+		Scores  []float32 `names:"-s" min:"-1.5" max:"1.5"`
+	}
+
+	for _, c := range []struct {
+		args []string
+		err  bool
+	}{
+		{[]string{"app", "-p", "1", "-r", "10%", "-w", "0", "-d", "-128", "-s", "-1.5"}, false},
+		{[]string{"app", "-p", "65535", "-r", "0.9", "-w", "100", "-d", "10", "-s", "1.5"}, false},
+		{[]string{"app", "-p", "0"}, true},
+		{[]string{"app", "-r", "95%"}, true},
+		{[]string{"app", "-r", "0.05"}, true},
+		{[]string{"app", "-w", "1", "-w", "-1"}, true},
+		{[]string{"app", "-d", "11"}, true},
+		{[]string{"app", "-s", "0", "-s", "1.6"}, true},
+	} {
+		var flags Flags
+		set := NewFlagSet(Flag{}).ErrHandling(0)
+		if err := set.StructFlags(&flags); err != nil {
+			t.Fatal(err)
+		}
+		err := set.Parse(c.args...)
+		if c.err != (err != nil) {
+			t.Fatal("min/max check failed", c.args, err)
+		}
+		if err != nil && err.(flagError).Type != errInvalidValue {
+			t.Fatal("min/max violation should be invalid value", c.args, err)
+		}
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if help := set.ToString(0); !strings.Contains(help, "default: 80; min: 1; max: 65535") || !strings.Contains(help, "min: 0.1; max: 0.9") {
+		t.Fatal("help should show min/max", help)
+	}
+
+	for _, st := range []interface{}{
+		&struct {
+			Name string `min:"1"`
+		}{},
+		&struct {
+			Timeout time.Duration `max:"10"`
+		}{},
+		&struct {
+			Port int `min:"a"`
+		}{},
+		&struct {
+			Port int `min:"10" max:"1"`
+		}{},
+	} {
+		err := NewFlagSet(Flag{}).ErrHandling(0).StructFlags(st)
+		if err == nil {
+			t.Fatal("invalid min/max should be reported", st)
+		}
+	}
+
+	var port int
+	set = NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.Flag(Flag{Names: "-p", Ptr: &port, Min: 1, Max: uint8(100)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse("app", "-p", "101"); err == nil {
+		t.Fatal("min/max of Flag should be checked")
+	}
+}
+
+func TestRepeatableSubset(t *testing.T) {
+	type Stage struct {
+		Enable  bool
+		Workers int    `names:"-w" default:"1"`
+		Image   string `names:"--image"`
+		Args    []string
+	}
+	type Flags struct {
+		Verbose bool    `names:"-v"`
+		Stages  []Stage `names:"stage" repeatable:"true" usage:"add pipeline stage"`
+		Run     struct {
+			Enable bool
+		}
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	err := set.Parse("app", "-v", "stage", "-w", "4", "build", "stage", "--image", "golang", "test", "stage", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []Stage{
+		{Enable: true, Workers: 4, Args: []string{"build"}},
+		{Enable: true, Workers: 1, Image: "golang", Args: []string{"test"}},
+		{Enable: true, Workers: 1, Args: []string{"deploy"}},
+	}
+	if !flags.Verbose || !reflect.DeepEqual(flags.Stages, expect) {
+		t.Fatal("repeatable subset failed", flags)
+	}
+	if !reflect.DeepEqual(set.ActiveSubcommand(), []string{set.self.Names, "stage"}) {
+		t.Fatal("active subcommand of repeatable subset failed", set.ActiveSubcommand())
+	}
+
+	flags = Flags{}
+	set.Reset()
+	if err := set.Parse("app", "run"); err != nil {
+		t.Fatal(err)
+	}
+	if len(flags.Stages) != 0 || !flags.Run.Enable {
+		t.Fatal("repeatable subset should be empty if absent", flags)
+	}
+
+	err = NewFlagSet(Flag{}).ErrHandling(0).StructFlags(&struct {
+		Stages []string `repeatable:"true"`
+	}{})
+	if err == nil || err.(flagError).Type != errInvalidType {
+		t.Fatal("repeatable subset should be slice of structure", err)
+	}
+}
+
+func TestArgFileComments(t *testing.T) {
+	content := `# build options
+--name "app #1"   # trailing comment
+
+-t 'a # b' -t c#d
+  # indented comment
+-t e\
+f --desc "multiple \
+lines" a.go
+`
+	file := writeTempFile(t, "comments.args", content)
+	defer removeTempFile(file)
+
+	var flags struct {
+		Name  string   `names:"--name"`
+		Desc  string   `names:"--desc"`
+		Tags  []string `names:"-t"`
+		Files []string `args:"true"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0).ExpandArgFiles(true)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse("test", "@"+file, "b.go"); err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "app #1" || flags.Desc != "multiple lines" ||
+		!reflect.DeepEqual(flags.Tags, []string{"a # b", "c#d", "ef"}) || !reflect.DeepEqual(flags.Files, []string{"a.go", "b.go"}) {
+		t.Fatal("comments of argument file should be ignored", flags)
+	}
+
+	unclosed := writeTempFile(t, "unclosed.args", "--name 'app\n")
+	defer removeTempFile(unclosed)
+	set.Reset()
+	err := set.Parse("test", "@"+unclosed)
+	if err == nil || err.(flagError).Type != errInvalidValue {
+		t.Fatal("unclosed quote of argument file should be reported", err)
+	}
+}
+
+func TestResetReapply(t *testing.T) {
+	type Flags struct {
+		Addr    string   `names:"--addr" env:"FLAG_TEST_REAPPLY_ADDR" default:":80"`
+		Workers int      `names:"-w" default:"4"`
+		Hosts   []string `names:"--hosts" env:"FLAG_TEST_REAPPLY_HOSTS"`
+		Debug   bool     `names:"-d"`
+		Sub     struct {
+			Enable bool
+			Level  int `names:"--level" default:"1"`
+		}
+	}
+
+	os.Setenv("FLAG_TEST_REAPPLY_HOSTS", "a,b")
+	defer os.Unsetenv("FLAG_TEST_REAPPLY_HOSTS")
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse("app", "--addr", ":8080", "-w", "8", "-d", "sub", "--level", "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	set.Reset()
+	if !reflect.DeepEqual(flags, Flags{}) {
+		t.Fatal("reset should leave zero values", flags)
+	}
+
+	os.Setenv("FLAG_TEST_REAPPLY_ADDR", ":9090")
+	defer os.Unsetenv("FLAG_TEST_REAPPLY_ADDR")
+	if err := set.ResetReapply(); err != nil {
+		t.Fatal(err)
+	}
+	expect := Flags{Addr: ":9090", Workers: 4, Hosts: []string{"a", "b"}}
+	if !reflect.DeepEqual(flags, expect) {
+		t.Fatal("environment and default values should be reapplied", flags)
+	}
+	var buf bytes.Buffer
+	if err := set.DumpValues(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if dump := buf.String(); !strings.Contains(dump, "--addr=:9090 (environment)") || !strings.Contains(dump, "-w=4 (default)") {
+		t.Fatal("source of reapplied value failed", dump)
+	}
+}
+
+func TestReparseSource(t *testing.T) {
+	type Flags struct {
+		Name  string `names:"--name" default:"def"`
+		Count int    `names:"-n"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse("app", "--name", "cli", "-n", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "cli" || flags.Count != 3 {
+		t.Fatal("parse failed", flags)
+	}
+	if err := set.Parse("app"); err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "def" {
+		t.Fatal("default value should be applied in reparsing", flags)
+	}
+	if err := set.ParseWith(ArgsSource("app", "-n", "1")); err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "def" || flags.Count != 1 {
+		t.Fatal("default value should be applied in reparsing by ParseWith", flags)
+	}
+}
+
+func TestReparseRequired(t *testing.T) {
+	type Flags struct {
+		Name string `names:"--name" required:"true"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse("app", "--name", "cli"); err != nil {
+		t.Fatal(err)
+	}
+	err := set.Parse("app")
+	if err == nil || err.(flagError).Type != errFlagValueNotProvided {
+		t.Fatal("required flag should be checked in each parsing", err)
+	}
+}
+
+func TestGroupProvided(t *testing.T) {
+	type Flags struct {
+		User     string `names:"--user" requires:"password"`
+		Password string `names:"--password"`
+	}
+
+	path := writeTempFile(t, "flag.json", `{"password": "secret"}`)
+	defer removeTempFile(path)
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse("app", "--user", "u", "--password", "p"); err != nil {
+		t.Fatal(err)
+	}
+	err := set.Parse("app", "--user", "u")
+	if err == nil || err.(flagError).Type != errFlagDependency {
+		t.Fatal("flag groups should be checked in each parsing", err)
+	}
+	if err = set.ParseJSON(path); err != nil {
+		t.Fatal(err)
+	}
+	if err = set.Parse("app", "--user", "u"); err != nil {
+		t.Fatal("config value should satisfy the dependency", err)
+	}
+	if flags.Password != "secret" {
+		t.Fatal("config value should be applied", flags)
+	}
+}
+
+func TestRequiresTagNames(t *testing.T) {
+	type Flags struct {
+		User     string `names:"~--login, --user" requires:"password"`
+		Password string `names:"--password"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	err := set.Parse("app", "--login", "u")
+	if err == nil || err.(flagError).Type != errFlagDependency || strings.Contains(err.Error(), "login") {
+		t.Fatal("requires group should be keyed by visible name", err)
+	}
+	if err = set.Parse("app", "--login", "u", "--password", "p"); err != nil {
+		t.Fatal(err)
+	}
+
+	type Invalid struct {
+		User string `names:"--user" requires:"passwd"`
+	}
+	err = NewFlagSet(Flag{}).ErrHandling(0).StructFlags(&Invalid{})
+	if err == nil || err.(flagError).Type != errFlagNotFound {
+		t.Fatal("unknown flag names of requires tag should be rejected when registering", err)
+	}
+}
+
+func TestNargsNegativeNumber(t *testing.T) {
+	var flags struct {
+		Size   []int `names:"--size" nargs:"2"`
+		Offset int   `names:"--offset"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	err := set.Parse("test", "--size", "1", "-2", "--size=-3", "-4", "--offset", "-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags.Size, []int{1, -2, -3, -4}) || flags.Offset != -5 {
+		t.Fatal("negative values of nargs flag should be consumed", flags.Size, flags.Offset)
+	}
+
+	set.Reset()
+	err = set.Parse("test", "--size", "1", "2", "-3")
+	if err == nil || err.(flagError).Type != errFlagNotFound {
+		t.Fatal("values more than nargs should not be consumed", err)
+	}
+}
+
+func TestHelpTemplateLabels(t *testing.T) {
+	var g GoCmd
+
+	set := NewFlagSet(Flag{Names: "go"})
+	set.StructFlags(&g)
+	set.HelpLabels(HelpLabels{Usage: "Uso:", Commands: "Comandos:"})
+	if err := set.SetHelpTemplate(DefaultHelpTemplate); err != nil {
+		t.Fatal(err)
+	}
+	if s := set.ToString(0); !strings.Contains(s, "Uso: go") || !strings.Contains(s, "Comandos:") ||
+		strings.Contains(s, "Usage:") || strings.Contains(s, "-race") {
+		t.Fatal("help labels should be applied to template", s)
+	}
+	if s := set.ToString(1); !strings.Contains(s, "-race") {
+		t.Fatal("subcommands should be expanded by verbose level", s)
+	}
+
+	builtin := NewFlagSet(Flag{Names: "go"})
+	builtin.StructFlags(&GoCmd{})
+	builtin.HelpLabels(HelpLabels{Usage: "Uso:", Commands: "Comandos:"}).HelpWidth(-1)
+	if s, expect := set.ToString(-1), builtin.ToString(-1); s != expect {
+		t.Fatal("default template should render the same as builtin help", s, expect)
+	}
+}
+
+func TestArgsTypedPtr(t *testing.T) {
+	var (
+		enable bool
+		nums   []int
+		names  []string
+	)
+	set := NewFlagSet(Flag{}).ErrHandling(0)
+	sub, err := set.Subset(Flag{Names: "sum", Ptr: &enable, ArgsTypedPtr: &nums})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = set.Subset(Flag{Names: "echo", Ptr: new(bool), ArgsPtr: &names}); err != nil {
+		t.Fatal(err)
+	}
+	if err = set.Parse("app", "sum", "1", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if !enable || !reflect.DeepEqual(nums, []int{1, 2}) || sub.self.ArgsPtr != nil {
+		t.Fatal("typed non-flag arguments should be converted", nums)
+	}
+	set.Reset()
+	if err = set.Parse("app", "echo", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatal("non-flag arguments should be stored to ArgsPtr", names)
+	}
+
+	_, err = set.Subset(Flag{Names: "bad", Ptr: new(bool), ArgsTypedPtr: new(int)})
+	if err == nil || err.(flagError).Type != errInvalidType {
+		t.Fatal("non-slice args pointer should be rejected", err)
+	}
+}
+
+func TestParseYAMLScalarText(t *testing.T) {
+	path := writeTempFile(t, "flag.yaml", `
+name: on
+port: 0x1F90
+tags: [yes, 1.0, off]
+debug: yes
+remote:
+  host: 1e3
+`)
+	defer removeTempFile(path)
+
+	var flags configFlags
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.ParseYAML(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse("test", "remote"); err != nil {
+		t.Fatal(err)
+	}
+	if flags.Name != "on" || flags.Port != 8080 || !flags.Debug || flags.Remote.Host != "1e3" ||
+		!reflect.DeepEqual(flags.Tags, []string{"yes", "1.0", "off"}) {
+		t.Fatal("original text of yaml scalars should be used for string flags", flags)
+	}
+}
+
+func TestAttachedSplitKinds(t *testing.T) {
+	var flags struct {
+		Dates []time.Time `names:"--date" layout:"Jan 2, 2006"`
+		URLs  []url.URL   `names:"--url"`
+		Seps  []rune      `names:"--sep" rune:"true"`
+		Tags  []string    `names:"--tag"`
+	}
+	set := NewFlagSet(Flag{Names: "test"}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	err := set.Parse("test", "--date=Jan 2, 2020", "--url=http://a.com/?q=1,2", "--sep=,", "--tag=a,b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flags.Dates) != 1 || flags.Dates[0].Year() != 2020 ||
+		len(flags.URLs) != 1 || flags.URLs[0].RawQuery != "q=1,2" ||
+		!reflect.DeepEqual(flags.Seps, []rune{','}) || !reflect.DeepEqual(flags.Tags, []string{"a", "b"}) {
+		t.Fatal("attached value should only be splitted for string and number slices", flags)
+	}
+}
+
+func TestHelpSkipsRequireOneOf(t *testing.T) {
+	type Flags struct {
+		JSON bool `names:"--json"`
+		YAML bool `names:"--yaml"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{Names: "tool", Version: "1.0"}).ErrHandling(0).ExitOnHelp(false)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.RequireOneOf("json", "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse("tool", "-h"); err != ErrHelp {
+		t.Fatal("help should be shown even if one-of group is not satisfied", err)
+	}
+	if err := set.Parse("tool", "--version"); err != ErrVersion {
+		t.Fatal("version should be shown even if one-of group is not satisfied", err)
+	}
+}
+
+func TestHelpSkipsRequires(t *testing.T) {
+	type Flags struct {
+		User string `names:"--user" requires:"password"`
+		Pass string `names:"--password"`
+		Cert string `names:"--cert"`
+		Key  string `names:"--key"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{Names: "tool", Version: "1.0"}).ErrHandling(0).ExitOnHelp(false)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.RequireTogether("cert", "key"); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"tool", "--user", "u", "-h"},
+		{"tool", "--cert", "c", "--help"},
+		{"tool", "--user", "u", "--cert", "c", "--version"},
+	} {
+		err := set.Parse(args...)
+		if err != ErrHelp && err != ErrVersion {
+			t.Fatal("help and version should be shown even if dependency is not satisfied", args, err)
+		}
+	}
+}
+
+func TestHelpSkipsMutuallyExclusive(t *testing.T) {
+	type Flags struct {
+		Gzip  bool `names:"-z"`
+		Bzip2 bool `names:"-j"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{Names: "tar", Version: "1.0"}).ErrHandling(0).ExitOnHelp(false)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.MutuallyExclusive("z", "j"); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse("tar", "-z", "-j", "-h"); err != ErrHelp {
+		t.Fatal("help should be shown even if exclusive flags conflict", err)
+	}
+	if err := set.Parse("tar", "-z", "-j", "--version"); err != ErrVersion {
+		t.Fatal("version should be shown even if exclusive flags conflict", err)
+	}
+}
+
+func TestHelpSkipsRepeatTogether(t *testing.T) {
+	type Flags struct {
+		Servers []string `names:"--server"`
+		Ports   []int    `names:"--port"`
+	}
+
+	var flags Flags
+	set := NewFlagSet(Flag{Names: "tool", Version: "1.0"}).ErrHandling(0).ExitOnHelp(false)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.RepeatTogether("server", "port"); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse("tool", "--server", "a", "--server", "b", "--port", "1", "-h"); err != ErrHelp {
+		t.Fatal("help should be shown even if repeated flags mismatch", err)
+	}
+	if err := set.Parse("tool", "--server", "a", "--version"); err != ErrVersion {
+		t.Fatal("version should be shown even if repeated flags mismatch", err)
+	}
+}
+
+func TestEnableFlagSubcommand(t *testing.T) {
+	var flags struct {
+		TLS struct {
+			Enable bool   `names:"--with-tls"`
+			Cert   string `names:"--cert" usage:"tls certificate"`
+		} `names:"tls"`
+		Remote struct {
+			Enable bool
+			Add    struct {
+				Enable bool
+				Name   string `names:"--name"`
+			}
+		}
+	}
+	set := NewFlagSet(Flag{Names: "tool"}).ErrHandling(0).ExitOnHelp(false)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse("tool", "--with-tls", "remote", "add", "--name", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if !flags.TLS.Enable || flags.Remote.Add.Name != "x" ||
+		!reflect.DeepEqual(set.ActiveSubcommand(), []string{"tool", "remote", "add"}) {
+		t.Fatal("subset enabled by flag should not be subcommand", set.ActiveSubcommand())
+	}
+
+	set.Reset()
+	if err := set.Parse("tool", "--with-tls"); err != nil {
+		t.Fatal(err)
+	}
+	if set.HasSubcommand() || !reflect.DeepEqual(set.ActiveSubcommand(), []string{"tool"}) {
+		t.Fatal("no subcommand should be resolved for enable flag", set.ActiveSubcommand())
+	}
+
+	set.Reset()
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	err = set.Parse("tool", "--with-tls", "-h")
+	w.Close()
+	os.Stdout = stdout
+	out, _ := ioutil.ReadAll(r)
+	if err != ErrHelp || !strings.Contains(string(out), "Usage: tool") {
+		t.Fatal("help of root should be shown for enable flag", err, string(out))
+	}
+}
+
+func TestActiveSubcommandAlias(t *testing.T) {
+	var flags struct {
+		Remote struct {
+			Enable bool
+			Add    struct {
+				Enable bool
+			} `names:"add, a"`
+		} `names:"remote, r"`
+	}
+	set := NewFlagSet(Flag{Names: "tool, t"}).ErrHandling(0)
+	if err := set.StructFlags(&flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Parse("t", "r", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(set.ActiveSubcommand(), []string{"tool", "remote", "add"}) {
+		t.Fatal("first names of commands should be reported", set.ActiveSubcommand())
+	}
+}
@@ -1,5 +1,9 @@
 module github.com/cosiner/flag
 
-require github.com/cosiner/argv v0.0.1
+require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/cosiner/argv v0.0.1
+	gopkg.in/yaml.v2 v2.2.8
+)
 
 go 1.4
@@ -0,0 +1,236 @@
+package flag
+
+import (
+	"reflect"
+	"strings"
+)
+
+type flagGroupKind uint8
+
+const (
+	// first flag requires all other flags
+	groupRequires flagGroupKind = iota + 1
+	// all flags must be set together
+	groupTogether
+	// exactly one flag must be set
+	groupOneOf
+	// at most one flag could be set
+	groupExclusive
+	// slice flags are repeated the same times on command line
+	groupRepeat
+)
+
+// flagGroup is the constraint between flags of same flagset, flags are referenced by name and
+// searched when checking, because flag pointers will be changed when flags slice grows.
+type flagGroup struct {
+	kind  flagGroupKind
+	names []string
+}
+
+func (f *FlagSet) groupFlags(names []string) ([]*Flag, error) {
+	flags := make([]*Flag, 0, len(names))
+	for _, name := range names {
+		flag := f.searchFlagRef(name)
+		if flag == nil {
+			return nil, newErrorf(errFlagNotFound, "flag %s is not found: %s", name, f.self.Names)
+		}
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
+// RequireTogether declare that if any of the flags is explicitly set by command line, environment
+// or config file, the others must be set too, default value doesn't satisfy the dependency. Flag
+// names could omit the leading dashes, and flags must be registered before.
+func (f *FlagSet) RequireTogether(names ...string) error {
+	_, err := f.groupFlags(names)
+	if err == nil {
+		f.groups = append(f.groups, flagGroup{kind: groupTogether, names: names})
+	}
+	return f.errorHandling.handle(err)
+}
+
+// RequireOneOf declare that exactly one of the flags must be explicitly set by command line,
+// environment or config file. Flag names could omit the leading dashes, and flags must be
+// registered before.
+func (f *FlagSet) RequireOneOf(names ...string) error {
+	_, err := f.groupFlags(names)
+	if err == nil {
+		f.groups = append(f.groups, flagGroup{kind: groupOneOf, names: names})
+	}
+	return f.errorHandling.handle(err)
+}
+
+// MutuallyExclusive declare that at most one of the flags could be explicitly set by command line,
+// environment or config file. Flag names could omit the leading dashes, and flags must be
+// registered before.
+func (f *FlagSet) MutuallyExclusive(names ...string) error {
+	_, err := f.groupFlags(names)
+	if err == nil {
+		f.groups = append(f.groups, flagGroup{kind: groupExclusive, names: names})
+	}
+	return f.errorHandling.handle(err)
+}
+
+// RepeatTogether declare that slice flags are repeated together on command line, values of them
+// are zipped by occurrence order, e.g. '--server a --port 1 --server b --port 2'. If any of them is
+// set by command line, all of them must be set with the same count of values, the i-th values of
+// them could be retrieved by Occurrences. Flag names could omit the leading dashes, and flags must
+// be registered before.
+func (f *FlagSet) RepeatTogether(names ...string) error {
+	flags, err := f.groupFlags(names)
+	if err == nil {
+		for _, flag := range flags {
+			if k := FlagKind(flag); !k.IsSlice() || k == KindStringSet {
+				err = newErrorf(errInvalidType, "flag of repeat group should be slice: %s", flag.Names)
+				break
+			}
+		}
+	}
+	if err == nil {
+		f.groups = append(f.groups, flagGroup{kind: groupRepeat, names: names})
+	}
+	return f.errorHandling.handle(err)
+}
+
+// Occurrences return formatted values of slice flags zipped by index after parsing, the i-th
+// element contains the i-th value of each flag in order of names. The flags must have the same
+// count of values, it's guaranteed by RepeatTogether for command line values.
+func (f *FlagSet) Occurrences(names ...string) ([][]string, error) {
+	flags, err := f.groupFlags(names)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		occurrences [][]string
+		count       = -1
+	)
+	for j, flag := range flags {
+		if k := FlagKind(flag); !k.IsSlice() || k == KindStringSet {
+			return nil, newErrorf(errInvalidType, "flag of occurrences should be slice: %s", flag.Names)
+		}
+		refval := reflect.ValueOf(flag.Ptr).Elem()
+		if count < 0 {
+			count = refval.Len()
+			occurrences = make([][]string, count)
+			for i := range occurrences {
+				occurrences[i] = make([]string, len(flags))
+			}
+		}
+		if refval.Len() != count {
+			return nil, newErrorf(errFlagDependency, "count of values mismatched: %s has %d, expect %d", flag.Names, refval.Len(), count)
+		}
+		for i := 0; i < count; i++ {
+			occurrences[i][j] = formatValue(flag, refval.Index(i).Interface())
+		}
+	}
+	return occurrences, nil
+}
+
+// isProvided report whether flag value is explicitly provided by command line, environment or config
+// file in current parsing, default value doesn't count. It's shared by required flags and flag groups.
+func (f *Flag) isProvided() bool {
+	return f.source == sourceCommandLine || f.source == sourceEnv || f.source == sourceConfig
+}
+
+func (r *resolver) checkGroups(f *FlagSet, context []string) error {
+	for _, group := range f.groups {
+		flags, err := f.groupFlags(group.names)
+		if err != nil {
+			return err
+		}
+
+		switch group.kind {
+		case groupRequires:
+			if !flags[0].isProvided() {
+				continue
+			}
+			for _, flag := range flags[1:] {
+				if !flag.isProvided() {
+					return newErrorf(errFlagDependency, "flag %v.%s requires %s", context, flags[0].Names, flag.Names)
+				}
+			}
+		case groupTogether:
+			var set, unset *Flag
+			for _, flag := range flags {
+				if flag.isProvided() {
+					set = flag
+				} else {
+					unset = flag
+				}
+			}
+			if set != nil && unset != nil {
+				return newErrorf(errFlagDependency, "flag %v.%s must be set together with %s", context, set.Names, unset.Names)
+			}
+		case groupRepeat:
+			var set, unset *Flag
+			for _, flag := range flags {
+				if flag.source == sourceCommandLine {
+					set = flag
+				} else {
+					unset = flag
+				}
+			}
+			if set == nil {
+				continue
+			}
+			if unset != nil {
+				return newErrorf(errFlagDependency, "flag %v.%s must be repeated together with %s", context, set.Names, unset.Names)
+			}
+			count := reflect.ValueOf(flags[0].Ptr).Elem().Len()
+			for _, flag := range flags[1:] {
+				if l := reflect.ValueOf(flag.Ptr).Elem().Len(); l != count {
+					return newErrorf(errFlagDependency, "flags must be repeated the same times: %v.[%s]", context, joinGroupNames(flags))
+				}
+			}
+		case groupOneOf, groupExclusive:
+			var set []string
+			for _, flag := range flags {
+				if flag.isProvided() {
+					set = append(set, flag.Names)
+				}
+			}
+			switch {
+			case len(set) == 0 && group.kind == groupOneOf:
+				return newErrorf(errFlagDependency, "one of flags must be set: %v.[%s]", context, joinGroupNames(flags))
+			case len(set) > 1:
+				return newErrorf(errFlagConflict, "only one of flags could be set: %v.[%s]", context, strings.Join(set, "|"))
+			}
+		}
+	}
+	return nil
+}
+
+// groupSynopsis return the usage notation of exclusive groups, '(a | b)' for required one and
+// '[a | b]' for optional one, other groups are not displayed.
+func groupSynopsis(f *FlagSet) []string {
+	var synopsis []string
+	for _, group := range f.groups {
+		if group.kind != groupOneOf && group.kind != groupExclusive {
+			continue
+		}
+		flags, err := f.groupFlags(group.names)
+		if err != nil {
+			continue
+		}
+		names := make([]string, 0, len(flags))
+		for _, flag := range flags {
+			ns, _ := defaultRegister.cleanFlagNames(flag.Names)
+			names = append(names, ns[0])
+		}
+		if group.kind == groupOneOf {
+			synopsis = append(synopsis, "("+strings.Join(names, " | ")+")")
+		} else {
+			synopsis = append(synopsis, "["+strings.Join(names, " | ")+"]")
+		}
+	}
+	return synopsis
+}
+
+func joinGroupNames(flags []*Flag) string {
+	names := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		names = append(names, flag.Names)
+	}
+	return strings.Join(names, "|")
+}
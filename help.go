@@ -9,12 +9,16 @@ import (
 const (
 	minInfoLen = 12
 	maxInfoLen = 24
+
+	helpPadding   = 4  // padding of tabwriter, it's also the width of indent
+	minWrapLength = 20 // wrapped text is at least this wide even if terminal is narrow
 )
 
 type helpWriter struct {
-	buf    *tabwriter.Writer
-	isTop  bool
-	indent string
+	buf          *tabwriter.Writer
+	indent       string
+	verboseLevel int
+	width        int // columns to wrap text, 0 means no wrapping
 }
 
 func (w *helpWriter) maxFlagInfoLen(f *FlagSet) int {
@@ -59,156 +63,280 @@ func (w *helpWriter) writeLines(indent string, lines []string) {
 	}
 }
 
-func (w *helpWriter) writeTopCommandInfo(currIndent string, f *FlagSet, normal, positional []*Flag) {
-	var arglist string
-	switch f.self.Arglist {
-	case "-":
-	default:
-		arglist = f.self.Arglist
-	case "":
-		var sb strings.Builder
-
-		flagCount, cmdCount := len(normal), len(f.subsets)
-		if flagCount != 0 {
-			if cmdCount != 0 {
-				sb.WriteString("[FLAG|COMMAND]...")
-			} else {
-				sb.WriteString("[FLAG]...")
+// wrapLines re-wrap lines to fit the width, the indent is the columns before text. Lines starting
+// with space and lines inside code fence are left unwrapped.
+func (w *helpWriter) wrapLines(indent int, lines []string) []string {
+	if w.width <= 0 {
+		return lines
+	}
+	width := w.width - indent
+	if width < minWrapLength {
+		width = minWrapLength
+	}
+	var (
+		wrapped []string
+		fenced  bool
+	)
+	for _, line := range lines {
+		if strings.HasPrefix(line, "```") {
+			fenced = !fenced
+		}
+		if fenced || strings.HasPrefix(line, "```") || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") ||
+			len([]rune(line)) <= width {
+			wrapped = append(wrapped, line)
+			continue
+		}
+		var curr string
+		for _, word := range strings.Fields(line) {
+			if curr != "" && len([]rune(curr))+1+len([]rune(word)) > width {
+				wrapped = append(wrapped, curr)
+				curr = ""
 			}
-		} else {
-			if cmdCount != 0 {
-				sb.WriteString("[COMMAND]...")
+			if curr != "" {
+				curr += " "
 			}
+			curr += word
 		}
-		if len(positional) > 0 || f.self.ArgsPtr != nil {
-			for _, p := range positional {
-				if sb.Len() > 0 {
-					sb.WriteString(" ")
-				}
-				sb.WriteString("[")
-				sb.WriteString(p.Arglist)
-				sb.WriteString("]")
-			}
-			if f.self.ArgsPtr != nil {
-				sb.WriteString(" [ARG]...")
+		wrapped = append(wrapped, curr)
+	}
+	return wrapped
+}
+
+// flagDescIndent return the columns before flag description, it's aligned to the second column of
+// flag lines by tabwriter.
+func (w *helpWriter) flagDescIndent(f *FlagSet) int {
+	var maxLen int
+	for i := range f.flags {
+		if l := len([]rune(flagInfo(&f.flags[i]))); l > maxLen {
+			maxLen = l
+		}
+	}
+	return helpPadding + maxLen + helpPadding
+}
+
+func usageArglist(f *FlagSet, normal, positional []*Flag) string {
+	switch {
+	case f.usageLine != nil:
+		return f.usageLine(f)
+	case f.self.Arglist == "-":
+		return ""
+	case f.self.Arglist != "":
+		return f.self.Arglist
+	}
+
+	var sb strings.Builder
+	flagCount, cmdCount := len(normal), len(f.subsets)
+	if flagCount != 0 {
+		if cmdCount != 0 {
+			sb.WriteString("[FLAG|COMMAND]...")
+		} else {
+			sb.WriteString("[FLAG]...")
+		}
+	} else {
+		if cmdCount != 0 {
+			sb.WriteString("[COMMAND]...")
+		}
+	}
+	for _, synopsis := range groupSynopsis(f) {
+		sb.WriteString(" ")
+		sb.WriteString(synopsis)
+	}
+	if len(positional) > 0 || f.self.argsPtr() != nil {
+		for _, p := range positional {
+			if sb.Len() > 0 {
+				sb.WriteString(" ")
 			}
+			sb.WriteString("[")
+			sb.WriteString(p.Arglist)
+			sb.WriteString("]")
+		}
+		if f.self.argsPtr() != nil {
+			sb.WriteString(" [ARG]...")
 		}
-		arglist = sb.String()
 	}
+	return sb.String()
+}
+
+func usageLine(f *FlagSet, normal, positional []*Flag) string {
+	return f.labels().Usage + " " + f.self.Names + " " + usageArglist(f, normal, positional)
+}
+
+func (w *helpWriter) writeTopCommandInfo(currIndent string, f *FlagSet, normal, positional []*Flag) {
 	if f.self.Usage != "" {
-		w.writeln(currIndent, f.self.Usage)
+		w.writeLines(currIndent, w.wrapLines(0, []string{f.self.Usage}))
 		w.writeln()
 	}
-	w.writeln(currIndent, "Usage: ", f.self.Names+" "+arglist)
+	w.writeln(currIndent, usageLine(f, normal, positional))
 }
 
-func (w *helpWriter) writeChildInfo(currIndent string, flag *Flag, isCommand bool) {
+func flagInfo(flag *Flag) string {
+	if flag.Names == flagNamePositional {
+		return flagNamePositional + flag.Arglist
+	}
+	if flag.Arglist != "" {
+		if isBoolPtr(flag.Ptr) || flag.AttachOnly {
+			return flag.Names + "=" + flag.Arglist
+		}
+		return flag.Names + " " + flag.Arglist
+	}
+	return flag.Names
+}
+
+// writeChildInfo write names, usage and value info of flag or command, usage lines except the first
+// one are written below it like descriptions.
+func (w *helpWriter) writeChildInfo(currIndent string, flag *Flag, isCommand bool, usage []string) {
 	w.write(currIndent)
 	var info string
 	if !isCommand {
-		if flag.Names == flagNamePositional {
-			info = flagNamePositional + flag.Arglist
-		} else if flag.Arglist != "" {
-			if isBoolPtr(flag.Ptr) {
-				info = flag.Names + "=" + flag.Arglist
-			} else {
-				info = flag.Names + " " + flag.Arglist
-			}
-		} else {
-			info = flag.Names
-		}
+		info = flagInfo(flag)
 	} else {
 		info = flag.Names
 	}
 	w.write(info)
-	if flag.Usage != "" {
-		w.write("\t", flag.Usage)
+	if len(usage) > 0 {
+		w.write("\t", usage[0])
 	} else {
 		w.write("\t")
 	}
 	if !isCommand {
 		w.write("\t")
-		w.writeFlagValueInfo(flag)
+		w.write(flagValueInfo(flag))
 	}
 	w.write("\n")
+	if len(usage) > 1 {
+		w.writeLines(w.nextIndent(currIndent), usage[1:])
+	}
 }
 
-func (w *helpWriter) writeFlagValueInfo(flag *Flag) {
-	w.write("(")
-	w.write("type: ", typeName(flag.Ptr))
-	if flag.Env != "" || flag.Default != nil || flag.Selects != nil {
+func flagValueInfo(flag *Flag) string {
+	var sb strings.Builder
+	sb.WriteString("(")
+	sb.WriteString("type: " + flagTypeName(flag))
+	if flag.Required {
+		sb.WriteString("; required")
+	}
+	if flag.EnvOnly {
+		sb.WriteString("; env only")
+	}
+	if flag.Invert {
+		sb.WriteString("; inverted")
+	}
+	if flag.Percent {
+		sb.WriteString("; percentage like 50% is accepted")
+	}
+	if isTimePtr(flag.Ptr) {
+		sb.WriteString("; layout: " + timeLayout(flag.Layout))
+	}
+	if flag.Env != "" || flag.Default != nil || flag.Selects != nil || flag.DenySelects != nil {
 		if flag.Env != "" {
-			w.write("; env: ", flag.Env)
-			if isSlicePtr(flag.Ptr) {
-				w.write(", splitted by ", fmt.Sprintf("'%s'", flag.ValSep))
+			sb.WriteString("; env: " + flag.Env)
+			if FlagKind(flag).IsSlice() {
+				sb.WriteString(", splitted by " + valSepName(flag.ValSep))
 			}
 		}
 		if flag.Default != nil {
-			w.write("; default: ", fmt.Sprintf("%v", flag.Default))
+			sb.WriteString("; default: " + formatDefault(flag))
 		}
 		if flag.Selects != nil {
-			w.write("; selects: ", fmt.Sprintf("%v", flag.Selects))
+			sb.WriteString("; selects: " + fmt.Sprintf("%v", flag.Selects))
+		}
+		if flag.DenySelects != nil {
+			sb.WriteString("; denied: " + fmt.Sprintf("%v", flag.DenySelects))
 		}
 	}
-	w.write(")")
+	if flag.Min != nil {
+		sb.WriteString(fmt.Sprintf("; min: %v", flag.Min))
+	}
+	if flag.Max != nil {
+		sb.WriteString(fmt.Sprintf("; max: %v", flag.Max))
+	}
+	sb.WriteString(")")
+	return sb.String()
 }
 
-func (w *helpWriter) writeCommand(f *FlagSet) {
-	var childIndent = w.nextIndent(w.indent)
+func valSepName(sep string) string {
+	if sep == nulValSep {
+		return "NUL"
+	}
+	return fmt.Sprintf("'%s'", sep)
+}
 
-	var normalFlags, positionalFlags []*Flag
+func splitPositionalFlags(f *FlagSet) (normal, positional []*Flag) {
 	for i := range f.flags {
-		f := &f.flags[i]
-		if f.Names == "@" {
-			positionalFlags = append(positionalFlags, f)
+		flag := &f.flags[i]
+		if flag.Names == flagNamePositional {
+			positional = append(positional, flag)
 		} else {
-			normalFlags = append(normalFlags, f)
+			normal = append(normal, flag)
 		}
 	}
-	if w.isTop {
-		w.writeTopCommandInfo(w.indent, f, normalFlags, positionalFlags)
-	} else {
-		w.writeChildInfo(w.indent, &f.self, true)
+	return normal, positional
+}
+
+func (w *helpWriter) writeCommand(f *FlagSet) {
+	var (
+		childIndent = w.nextIndent(w.indent)
+		labels      = f.labels()
+	)
+
+	normalFlags, positionalFlags := splitPositionalFlags(f)
+	w.writeTopCommandInfo(w.indent, f, normalFlags, positionalFlags)
+	if len(f.self.versionLines) > 0 {
+		w.writeln()
+		w.writeln(w.indent, labels.Version)
+		w.writeLines(childIndent, f.self.versionLines)
 	}
-	if !w.isTop {
-		return
+	if len(f.self.descLines) > 0 {
+		w.writeln()
+		w.writeln(w.indent, labels.Description)
+		w.writeLines(childIndent, w.wrapLines(helpPadding, f.self.descLines))
 	}
 
-	if w.isTop {
-		if len(f.self.versionLines) > 0 {
-			w.writeln()
-			w.writeln(w.indent, "Version:")
-			w.writeLines(childIndent, f.self.versionLines)
-		}
-		if len(f.self.descLines) > 0 {
-			w.writeln()
-			w.writeln(w.indent, "Description:")
-			w.writeLines(childIndent, f.self.descLines)
-		}
+	if len(f.flags) > 0 {
+		w.writeln()
+		w.writeln(w.indent, labels.Flags)
+		w.writeFlags(childIndent, f)
 	}
 
-	if len(f.flags) > 0 {
+	if len(f.subsets) > 0 {
 		w.writeln()
-		w.writeln(w.indent, "Flags:")
-		for i := range f.flags {
-			flag := &f.flags[i]
+		w.writeln(w.indent, labels.Commands)
+		w.writeSubsets(childIndent, f, 0)
+	}
+}
 
-			w.writeChildInfo(childIndent, flag, false)
-			if len(flag.descLines) > 0 {
-				w.writeLines(w.nextIndent(childIndent), flag.descLines)
-			}
+func (w *helpWriter) writeFlags(indent string, f *FlagSet) {
+	descIndent := w.flagDescIndent(f)
+	for i := range f.flags {
+		flag := &f.flags[i]
+
+		var usage []string
+		if flag.Usage != "" {
+			usage = w.wrapLines(descIndent, []string{flag.Usage})
+		}
+		w.writeChildInfo(indent, flag, false, usage)
+		if len(flag.descLines) > 0 {
+			w.writeLines(w.nextIndent(indent), w.wrapLines(descIndent, flag.descLines))
 		}
 	}
+}
 
-	if len(f.subsets) > 0 {
-		if w.isTop {
-			w.writeln()
-			w.writeln(w.indent, "Commands:")
-		}
-		for i := range f.subsets {
-			set := &f.subsets[i]
+// writeSubsets write subsets of flagset, flags and subsets of them are expanded recursively if
+// depth is less than verbose level, negative verbose level means expanding all.
+func (w *helpWriter) writeSubsets(indent string, f *FlagSet, depth int) {
+	expand := w.verboseLevel < 0 || depth < w.verboseLevel
+	for i := range f.subsets {
+		set := &f.subsets[i]
 
-			w.writeChildInfo(childIndent, &set.self, true)
+		var usage []string
+		if set.self.Usage != "" {
+			usage = []string{set.self.Usage}
+		}
+		w.writeChildInfo(indent, &set.self, true, usage)
+		if !expand {
+			continue
 		}
+		w.writeFlags(w.nextIndent(indent), set)
+		w.writeSubsets(w.nextIndent(indent), set, depth+1)
 	}
 }
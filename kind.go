@@ -0,0 +1,245 @@
+package flag
+
+import (
+	"net"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// Kind is the value kind of flag.
+type Kind uint8
+
+const (
+	// KindInvalid means the flag value type is unsupported
+	KindInvalid Kind = iota
+	KindInt
+	KindInt8
+	KindInt16
+	KindInt32
+	KindInt64
+	KindUint
+	KindUint8
+	KindUint16
+	KindUint32
+	KindUint64
+	KindFloat32
+	KindFloat64
+	KindString
+	KindBool
+	KindTime
+	KindDuration
+	KindIP
+	KindURL
+)
+
+const (
+	kindSlice Kind = 1 << 6
+	kindSet   Kind = 1 << 7
+)
+
+const (
+	KindIntSlice      = KindInt | kindSlice
+	KindInt8Slice     = KindInt8 | kindSlice
+	KindInt16Slice    = KindInt16 | kindSlice
+	KindInt32Slice    = KindInt32 | kindSlice
+	KindInt64Slice    = KindInt64 | kindSlice
+	KindUintSlice     = KindUint | kindSlice
+	KindUint8Slice    = KindUint8 | kindSlice
+	KindUint16Slice   = KindUint16 | kindSlice
+	KindUint32Slice   = KindUint32 | kindSlice
+	KindUint64Slice   = KindUint64 | kindSlice
+	KindFloat32Slice  = KindFloat32 | kindSlice
+	KindFloat64Slice  = KindFloat64 | kindSlice
+	KindStringSlice   = KindString | kindSlice
+	KindBoolSlice     = KindBool | kindSlice
+	KindTimeSlice     = KindTime | kindSlice
+	KindDurationSlice = KindDuration | kindSlice
+	KindIPSlice       = KindIP | kindSlice
+	KindURLSlice      = KindURL | kindSlice
+
+	// KindStringSet is the kind of map[string]bool, each value is a present key, it's treated as slice
+	// of keys.
+	KindStringSet = KindString | kindSlice | kindSet
+)
+
+var kindNames = map[Kind]string{
+	KindInt:      "int",
+	KindInt8:     "int8",
+	KindInt16:    "int16",
+	KindInt32:    "int32",
+	KindInt64:    "int64",
+	KindUint:     "uint",
+	KindUint8:    "uint8",
+	KindUint16:   "uint16",
+	KindUint32:   "uint32",
+	KindUint64:   "uint64",
+	KindFloat32:  "float32",
+	KindFloat64:  "float64",
+	KindString:   "string",
+	KindBool:     "bool",
+	KindTime:     "time",
+	KindDuration: "duration",
+	KindIP:       "ip",
+	KindURL:      "url",
+}
+
+// IsSlice report whether the kind is a slice kind, KindStringSet is also reported as slice kind.
+func (k Kind) IsSlice() bool {
+	return k&kindSlice != 0 && k.Elem() != KindInvalid
+}
+
+// Elem return the element kind of slice kind, for non-slice kind, it return itself.
+func (k Kind) Elem() Kind {
+	return k &^ (kindSlice | kindSet)
+}
+
+func (k Kind) isNumber() bool {
+	return KindInt <= k && k <= KindFloat64
+}
+
+func (k Kind) String() string {
+	name, has := kindNames[k.Elem()]
+	if !has {
+		return "invalid"
+	}
+	if k == KindStringSet {
+		return "map[" + name + "]bool"
+	}
+	if k.IsSlice() {
+		return "[]" + name
+	}
+	return name
+}
+
+// FlagKind return the value kind of flag, raw []byte flag is reported as KindString.
+func FlagKind(f *Flag) Kind {
+	k := kindOf(f.Ptr)
+	if f.Raw && k == KindUint8Slice {
+		return KindString
+	}
+	return k
+}
+
+func kindOf(ptr interface{}) Kind {
+	switch ptr.(type) {
+	case *int:
+		return KindInt
+	case *int8:
+		return KindInt8
+	case *int16:
+		return KindInt16
+	case *int32:
+		return KindInt32
+	case *int64:
+		return KindInt64
+	case *[]int:
+		return KindIntSlice
+	case *[]int8:
+		return KindInt8Slice
+	case *[]int16:
+		return KindInt16Slice
+	case *[]int32:
+		return KindInt32Slice
+	case *[]int64:
+		return KindInt64Slice
+	case *uint:
+		return KindUint
+	case *uint8:
+		return KindUint8
+	case *uint16:
+		return KindUint16
+	case *uint32:
+		return KindUint32
+	case *uint64:
+		return KindUint64
+	case *[]uint:
+		return KindUintSlice
+	case *[]uint8:
+		return KindUint8Slice
+	case *[]uint16:
+		return KindUint16Slice
+	case *[]uint32:
+		return KindUint32Slice
+	case *[]uint64:
+		return KindUint64Slice
+	case *float32:
+		return KindFloat32
+	case *float64:
+		return KindFloat64
+	case *[]float32:
+		return KindFloat32Slice
+	case *[]float64:
+		return KindFloat64Slice
+	case *string:
+		return KindString
+	case *[]string:
+		return KindStringSlice
+	case *map[string]bool:
+		return KindStringSet
+	case *bool:
+		return KindBool
+	case *[]bool:
+		return KindBoolSlice
+	case *time.Time:
+		return KindTime
+	case *[]time.Time:
+		return KindTimeSlice
+	case *time.Duration:
+		return KindDuration
+	case *[]time.Duration:
+		return KindDurationSlice
+	case *net.IP:
+		return KindIP
+	case *[]net.IP:
+		return KindIPSlice
+	case *url.URL:
+		return KindURL
+	case *[]url.URL:
+		return KindURLSlice
+	}
+	if isOptionalPtr(ptr) {
+		return kindOf(probePtr(ptr))
+	}
+	if base := namedBaseType(ptr); base != nil {
+		k := kindOf(reflect.New(base).Interface())
+		if reflect.TypeOf(ptr).Elem().Kind() == reflect.Slice {
+			k |= kindSlice
+		}
+		return k
+	}
+	return KindInvalid
+}
+
+// namedBaseTypes is the types which values of named types with the same underlying kind are
+// parsed as, e.g. 'type Format string' is parsed as string and 'type Level uint8' is parsed as uint8.
+var namedBaseTypes = map[reflect.Kind]reflect.Type{
+	reflect.Int:     reflect.TypeOf(int(0)),
+	reflect.Int8:    reflect.TypeOf(int8(0)),
+	reflect.Int16:   reflect.TypeOf(int16(0)),
+	reflect.Int32:   reflect.TypeOf(int32(0)),
+	reflect.Int64:   reflect.TypeOf(int64(0)),
+	reflect.Uint:    reflect.TypeOf(uint(0)),
+	reflect.Uint8:   reflect.TypeOf(uint8(0)),
+	reflect.Uint16:  reflect.TypeOf(uint16(0)),
+	reflect.Uint32:  reflect.TypeOf(uint32(0)),
+	reflect.Uint64:  reflect.TypeOf(uint64(0)),
+	reflect.Float32: reflect.TypeOf(float32(0)),
+	reflect.Float64: reflect.TypeOf(float64(0)),
+	reflect.String:  reflect.TypeOf(""),
+	reflect.Bool:    reflect.TypeOf(false),
+}
+
+// namedBaseType return the base type of named type pointer or pointer of slice of named type,
+// it's nil if the underlying kind is not supported.
+func namedBaseType(ptr interface{}) reflect.Type {
+	typ := reflect.TypeOf(ptr)
+	if typ == nil || typ.Kind() != reflect.Ptr {
+		return nil
+	}
+	typ = typ.Elem()
+	if typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	return namedBaseTypes[typ.Kind()]
+}
@@ -0,0 +1,144 @@
+package flag
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const maxMarkdownHeading = 6
+
+// Markdown return help message of flagset in GitHub flavored markdown for documentation, each command
+// is a section with a fenced synopsis, a flags table and links to sections of it's subcommands, sections
+// of subcommands follow recursively.
+func (f *FlagSet) Markdown() (string, error) {
+	var buf bytes.Buffer
+	err := writeMarkdown(&buf, f, nil)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func writeMarkdown(buf *bytes.Buffer, f *FlagSet, path []string) error {
+	names, _ := defaultRegister.cleanFlagNames(f.self.Names)
+	if len(names) == 0 {
+		return newErrorf(errInvalidStructure, "command name is empty: %v", path)
+	}
+	path = append(path[:len(path):len(path)], names[0])
+
+	level := len(path)
+	if level > maxMarkdownHeading {
+		level = maxMarkdownHeading
+	}
+	fmt.Fprintf(buf, "%s %s\n\n", strings.Repeat("#", level), strings.Join(path, " "))
+	if f.self.Usage != "" {
+		fmt.Fprintf(buf, "%s\n\n", f.self.Usage)
+	}
+	labels := f.labels()
+	normal, positional := splitPositionalFlags(f)
+	fmt.Fprintf(buf, "```\n%s %s\n```\n\n", strings.Join(path, " "), usageArglist(f, normal, positional))
+	if len(f.self.descLines) > 0 {
+		fmt.Fprintf(buf, "%s\n\n", strings.Join(f.self.descLines, "\n"))
+	}
+
+	if len(f.flags) > 0 {
+		fmt.Fprintf(buf, "%s\n\n", labels.Flags)
+		buf.WriteString("| Name | Type | Default | Description |\n")
+		buf.WriteString("| --- | --- | --- | --- |\n")
+		for i := range f.flags {
+			flag := &f.flags[i]
+			var def string
+			if flag.Default != nil {
+				def = "`" + formatDefault(flag) + "`"
+			}
+			fmt.Fprintf(buf, "| `%s` | %s | %s | %s |\n",
+				markdownCell(flagInfo(flag)), markdownCell(flagTypeName(flag)), markdownCell(def), markdownCell(markdownFlagDesc(flag)))
+		}
+		buf.WriteString("\n")
+	}
+
+	if len(f.subsets) > 0 {
+		fmt.Fprintf(buf, "%s\n\n", labels.Commands)
+		for i := range f.subsets {
+			set := &f.subsets[i]
+			subNames, _ := defaultRegister.cleanFlagNames(set.self.Names)
+			if len(subNames) == 0 {
+				continue
+			}
+			fmt.Fprintf(buf, "* [%s](#%s)", set.self.Names, markdownAnchor(append(path[:len(path):len(path)], subNames[0])))
+			if set.self.Usage != "" {
+				fmt.Fprintf(buf, ": %s", set.self.Usage)
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString("\n")
+	}
+
+	for i := range f.subsets {
+		err := writeMarkdown(buf, &f.subsets[i], path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markdownFlagDesc return usage, description and constraints of flag, type and default value are
+// listed in other columns.
+func markdownFlagDesc(flag *Flag) string {
+	var descs []string
+	if flag.Usage != "" {
+		descs = append(descs, flag.Usage)
+	}
+	descs = append(descs, flag.descLines...)
+
+	var notes []string
+	if flag.Required {
+		notes = append(notes, "required")
+	}
+	if flag.EnvOnly {
+		notes = append(notes, "env only")
+	}
+	if flag.Env != "" {
+		notes = append(notes, "env: `"+flag.Env+"`")
+	}
+	if flag.Selects != nil {
+		notes = append(notes, fmt.Sprintf("selects: `%v`", flag.Selects))
+	}
+	if flag.DenySelects != nil {
+		notes = append(notes, fmt.Sprintf("denied: `%v`", flag.DenySelects))
+	}
+	if flag.Min != nil {
+		notes = append(notes, fmt.Sprintf("min: `%v`", flag.Min))
+	}
+	if flag.Max != nil {
+		notes = append(notes, fmt.Sprintf("max: `%v`", flag.Max))
+	}
+	if len(notes) > 0 {
+		descs = append(descs, "("+strings.Join(notes, "; ")+")")
+	}
+	return strings.Join(descs, "<br>")
+}
+
+// markdownCell escape pipes and newlines which break table cell.
+func markdownCell(s string) string {
+	s = strings.Replace(s, "|", "\\|", -1)
+	return strings.Replace(s, "\n", "<br>", -1)
+}
+
+// markdownAnchor return anchor of heading generated by GitHub, it's lower case heading with spaces
+// replaced by '-' and punctuations except '-' and '_' removed.
+func markdownAnchor(path []string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(strings.Join(path, " ")) {
+		switch {
+		case r == ' ':
+			sb.WriteRune('-')
+		case r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
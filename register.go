@@ -3,14 +3,148 @@ package flag
 import (
 	"go/ast"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"unicode"
 )
 
 const (
 	flagNamePositional = "@"
+	flagNameHiddenMark = "~"
 )
 
+const (
+	tagNames   = "names"
+	tagArglist = "arglist"
+	tagUsage   = "usage"
+	tagDesc    = "desc"
+	tagVersion = "version"
+
+	tagEnv          = "env"
+	tagValsep       = "valsep"
+	tagDefault      = "default"
+	tagSelects      = "selects"
+	tagDenySelects  = "denyselects"
+	tagLayout       = "layout"
+	tagRaw          = "raw"
+	tagSelectsCI    = "selectsci"
+	tagAttachOnly   = "attachonly"
+	tagArgs         = "args"
+	tagArgsAnywhere = "argsAnywhere"
+	tagArgsDefault  = "argsdefault"
+	tagRequires     = "requires"
+	tagSplit        = "split"
+	tagNargs        = "nargs"
+	tagRequired     = "required"
+	tagClearable    = "clearable"
+	tagBoolValue    = "boolvalue"
+	tagEnvOnly      = "envonly"
+	tagSecret       = "secret"
+	tagRune         = "rune"
+	tagPercent      = "percent"
+	tagInvert       = "invert"
+	tagEnableIf     = "enableif"
+	tagMin          = "min"
+	tagMax          = "max"
+	tagRepeatable   = "repeatable"
+
+	tagFlag              = "flag"
+	tagFlagSeparator     = ";"
+	tagFlagKeyValueSplit = "="
+
+	fieldSubsetEnable = "Enable"
+	fieldArgs         = "Args"
+)
+
+var knownTags = []string{
+	tagNames, tagArglist, tagUsage, tagDesc, tagVersion,
+	tagEnv, tagValsep, tagDefault, tagSelects, tagDenySelects, tagLayout, tagRaw, tagSelectsCI, tagAttachOnly, tagRequires, tagRequired, tagClearable, tagBoolValue, tagEnvOnly, tagSecret, tagRune, tagPercent, tagInvert, tagEnableIf, tagMin, tagMax, tagRepeatable, tagSplit, tagNargs, tagArgs, tagArgsDefault, tagArgsAnywhere,
+}
+
+// fieldTags is the tags of structure field, values in the consolidated 'flag' tag take precedence.
+type fieldTags struct {
+	tag        reflect.StructTag
+	namespaced map[string]string
+	resolved   map[string]string // values of known tags, it's filled for cached fields
+}
+
+func (t fieldTags) Get(key string) string {
+	if t.resolved != nil {
+		return t.resolved[key]
+	}
+	if val, has := t.namespaced[key]; has {
+		return val
+	}
+	return t.tag.Get(key)
+}
+
+// structField is the metadata of structure field, it only depends on structure type and is
+// shared by all registrations of the type, so it must not be modified.
+type structField struct {
+	field    reflect.StructField
+	exported bool
+	tagKeys  []string // keys of struct tag, it's used by strict tags checking
+	flagKeys []string // keys of consolidated 'flag' tag before invalid section
+	invalid  string   // the first invalid section of 'flag' tag
+	tags     fieldTags
+}
+
+var structFieldsCache = struct {
+	sync.RWMutex
+	fields map[reflect.Type][]structField
+}{fields: make(map[reflect.Type][]structField)}
+
+// cachedStructFields return metadata of fields of structure type, they are parsed at first time.
+func cachedStructFields(typ reflect.Type) []structField {
+	structFieldsCache.RLock()
+	fields, has := structFieldsCache.fields[typ]
+	structFieldsCache.RUnlock()
+	if has {
+		return fields
+	}
+
+	fields = make([]structField, typ.NumField())
+	for i := range fields {
+		field := typ.Field(i)
+		sf := structField{
+			field:    field,
+			exported: ast.IsExported(field.Name),
+			tagKeys:  structTagKeys(field.Tag),
+			tags:     fieldTags{tag: field.Tag},
+		}
+		if flagTag, has := field.Tag.Lookup(tagFlag); has {
+			sf.tags.namespaced = make(map[string]string)
+			for _, sec := range strings.Split(flagTag, tagFlagSeparator) {
+				if strings.TrimSpace(sec) == "" {
+					continue
+				}
+				kv := strings.SplitN(sec, tagFlagKeyValueSplit, 2)
+				key := strings.TrimSpace(kv[0])
+				if len(kv) != 2 || key == "" {
+					sf.invalid = sec
+					break
+				}
+				sf.flagKeys = append(sf.flagKeys, key)
+				sf.tags.namespaced[key] = strings.TrimSpace(kv[1])
+			}
+		}
+		resolved := make(map[string]string)
+		for _, key := range knownTags {
+			if val := sf.tags.Get(key); val != "" {
+				resolved[key] = val
+			}
+		}
+		sf.tags.resolved = resolved
+		fields[i] = sf
+	}
+
+	structFieldsCache.Lock()
+	structFieldsCache.fields[typ] = fields
+	structFieldsCache.Unlock()
+	return fields
+}
+
 type register struct {
 }
 
@@ -42,11 +176,117 @@ func (r register) findDuplicates(parent, set *FlagSet, names []string) []string
 	return duplicates
 }
 
+// setPath return the full command path of flagset from root, empty root name is omitted.
+func (r register) setPath(set *FlagSet) string {
+	var names []string
+	for _, name := range append(set.parentPath, set.self.Names) {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "<root>"
+	}
+	return strings.Join(names, ".")
+}
+
+// duplicateError describe the first name collision precisely, including the kind and the full path
+// of the conflicting level.
+func (r register) duplicateError(parent, set *FlagSet, names []string, isSubset bool) error {
+	kind := "flag"
+	if isSubset {
+		kind = "subset"
+	}
+	levels := []*FlagSet{set}
+	if parent != nil {
+		levels = append(levels, parent)
+	}
+	for i := range set.subsets {
+		levels = append(levels, &set.subsets[i])
+	}
+	for _, name := range names {
+		if name == flagNamePositional {
+			continue
+		}
+		for _, level := range levels {
+			var conflict string
+			switch {
+			case level.isFlag(name):
+				conflict = "flag"
+			case level.isSubset(name):
+				conflict = "subset"
+			default:
+				continue
+			}
+			return newErrorf(errDuplicateFlagRegister, "%s name collides with %s at level %s: %s.%s",
+				kind, conflict, r.setPath(level), r.setPath(set), name)
+		}
+	}
+	return nil
+}
+
+func (r register) isShortFlagName(name string) bool {
+	return len(name) > 1 && name[0] == '-' && name[1] != '-' && len([]rune(name)) == 2
+}
+
+func (r register) isBundleFlagName(name string) bool {
+	return len(name) > 1 && name[0] == '-' && name[1] != '-' && len([]rune(name)) > 2
+}
+
+// bundleConflict return the flags which bundle name could be splitted to, e.g. '-zc' could be
+// splitted to '-z' and '-c', name is the flag name that is being registered.
+func (r register) bundleConflict(set *FlagSet, bundle, name string) []string {
+	var flags []string
+	for _, c := range bundle[1:] {
+		short := "-" + string(c)
+		if short != name && !set.isFlag(short) {
+			return nil
+		}
+		flags = append(flags, short)
+	}
+	return flags
+}
+
+// findBundleConflict find the flag names that make short flag bundling ambiguous.
+func (r register) findBundleConflict(set *FlagSet, names []string) (name, conflict string) {
+	if set.noBundling {
+		return "", ""
+	}
+	for _, name := range names {
+		switch {
+		case r.isBundleFlagName(name):
+			if flags := r.bundleConflict(set, name, ""); len(flags) > 0 {
+				return name, r.joinFlagNames(flags)
+			}
+		case r.isShortFlagName(name):
+			for i := range set.flags {
+				for _, bundle := range splitAndTrimSpace(set.flags[i].Names, flagNameSeparatorForSplit) {
+					if !r.isBundleFlagName(bundle) || !strings.ContainsRune(bundle, []rune(name)[1]) {
+						continue
+					}
+					if flags := r.bundleConflict(set, bundle, name); len(flags) > 0 {
+						return name, bundle
+					}
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
 const (
 	flagNameSeparatorForSplit = ","
 	flagNameSeparatorForJoin  = ", "
 )
 
+// parseValSep convert valsep tag value, 'nul' and '\0' mean the NUL byte.
+func (r register) parseValSep(valsep string) string {
+	if valsep == "nul" || valsep == `\0` {
+		return nulValSep
+	}
+	return valsep
+}
+
 func (r register) joinFlagNames(names []string) string {
 	return strings.Join(names, flagNameSeparatorForJoin)
 }
@@ -65,11 +305,17 @@ func (r register) cleanFlag(flag *Flag) {
 }
 
 func (r register) updateFlagDefault(flag *Flag, def interface{}) error {
-	refPtr := reflect.ValueOf(flag.Ptr)
+	if s, ok := def.(string); ok && hasFlagRef(s) {
+		flag.Default = def
+		return nil
+	}
+	refPtr := reflect.ValueOf(probePtr(flag.Ptr))
 	var compatible bool
 
 	refdef := reflect.ValueOf(def)
-	if isRefvalSlicePtr(refPtr) {
+	if refdef.Type() == refPtr.Elem().Type() {
+		compatible = true
+	} else if isRefvalSlicePtr(refPtr) {
 		compatible = refdef.Kind() == reflect.Slice
 		compatible = compatible && isKindCompatible(sliceElemKind(refPtr.Elem()), sliceElemKind(refdef))
 	} else {
@@ -86,21 +332,82 @@ func (r register) updateFlagSelects(flag *Flag, val interface{}) error {
 	if val == nil {
 		return nil
 	}
+	selects, err := r.normalizeSelects(flag, val)
+	if err != nil {
+		return err
+	}
+	flag.Selects = selects
+	return nil
+}
 
-	refval := reflect.ValueOf(flag.Ptr).Elem()
-	k := sliceElemKind(refval)
-	if isKindNumber(k) {
-		fs := convertNumbersToFloats(val)
-		flag.Selects = fs
+func (r register) updateFlagDenySelects(flag *Flag, val interface{}) error {
+	if val == nil {
 		return nil
 	}
+	selects, err := r.normalizeSelects(flag, val)
+	if err != nil {
+		return err
+	}
+	flag.DenySelects = selects
+	return nil
+}
+
+// normalizeSelects convert selects to the form compared by checkSelects, numbers are converted to
+// []float64 and values of parsed kinds are formatted.
+func (r register) normalizeSelects(flag *Flag, val interface{}) (interface{}, error) {
+	refval := reflect.ValueOf(probePtr(flag.Ptr)).Elem()
+	k := sliceElemKind(refval)
+	if isParsedKind(FlagKind(flag)) {
+		if vals, ok := val.([]string); ok && len(vals) != 0 {
+			return formatSelects(flag, vals)
+		}
+	} else if isKindNumber(k) {
+		return convertNumbersToFloats(val), nil
+	}
 	if k == reflect.String {
 		if vals, ok := val.([]string); ok && len(vals) != 0 {
-			flag.Selects = vals
-			return nil
+			return vals, nil
 		}
 	}
-	return newErrorf(errInvalidSelects, "invalid selects: %s, %v", flag.Names, val)
+	return nil, newErrorf(errInvalidSelects, "invalid selects: %s, %v", flag.Names, val)
+}
+
+// updateFlagBounds convert min and max of numeric flag to float64, string bound is converted like
+// value such as percentage.
+func (r register) updateFlagBounds(flag *Flag) error {
+	k := sliceElemKind(reflect.ValueOf(probePtr(flag.Ptr)).Elem())
+	if !isKindNumber(k) || isParsedKind(FlagKind(flag)) {
+		return newErrorf(errInvalidType, "min/max flag should be number: %s", flag.Names)
+	}
+	for _, bound := range []*interface{}{&flag.Min, &flag.Max} {
+		if *bound == nil {
+			continue
+		}
+		var (
+			val float64
+			err error
+		)
+		if s, ok := (*bound).(string); ok {
+			if convert := valueConverter(flag); convert != nil {
+				s, err = convert(s)
+			}
+			if err == nil {
+				val, err = strconv.ParseFloat(s, 64)
+			}
+		} else if refval := reflect.ValueOf(*bound); isKindNumber(refval.Kind()) {
+			val = refval.Convert(reflect.TypeOf(val)).Float()
+		} else {
+			err = newErrorf(errInvalidType, "not a number")
+		}
+		if err != nil {
+			return newErrorf(errInvalidValue, "invalid min/max value: %s, %v", flag.Names, *bound)
+		}
+		*bound = val
+	}
+	if flag.Min != nil && flag.Max != nil && flag.Min.(float64) > flag.Max.(float64) {
+		return newErrorf(errInvalidValue, "min is greater than max: %s, %v > %v", flag.Names, flag.Min, flag.Max)
+	}
+	return nil
 }
 
 func (r register) registerFlag(parent, set *FlagSet, flag Flag) error {
@@ -120,6 +427,36 @@ func (r register) registerFlag(parent, set *FlagSet, flag Flag) error {
 	if typeName(flag.Ptr) == "" {
 		return newErrorf(errInvalidType, "unsupported flag type: %s", flag.Names)
 	}
+	if flag.Raw && kindOf(flag.Ptr) != KindUint8Slice {
+		return newErrorf(errInvalidType, "raw flag should be []byte: %s", flag.Names)
+	}
+	if flag.BoolValue && (FlagKind(&flag).IsSlice() || !isBoolPtr(flag.Ptr)) {
+		return newErrorf(errInvalidType, "boolvalue flag should be bool: %s", flag.Names)
+	}
+	if elem := FlagKind(&flag).Elem(); flag.Percent && elem != KindFloat32 && elem != KindFloat64 {
+		return newErrorf(errInvalidType, "percent flag should be float: %s", flag.Names)
+	}
+	if flag.Invert && (FlagKind(&flag).IsSlice() || !isBoolPtr(flag.Ptr)) {
+		return newErrorf(errInvalidType, "invert flag should be bool: %s", flag.Names)
+	}
+	if flag.Rune && FlagKind(&flag).Elem() != KindInt32 {
+		return newErrorf(errInvalidType, "rune flag should be int32: %s", flag.Names)
+	}
+	if flag.EnvOnly && (flag.Env == "" || flag.Names == flagNamePositional) {
+		return newErrorf(errInvalidValue, "env-only flag must have environment name: %s", flag.Names)
+	}
+	if flag.Clearable && !FlagKind(&flag).IsSlice() {
+		return newErrorf(errInvalidType, "clearable flag should be slice: %s", flag.Names)
+	}
+	if flag.Nargs < 0 || (flag.Nargs > 0 && !FlagKind(&flag).IsSlice()) {
+		return newErrorf(errInvalidType, "nargs flag should be slice: %s", flag.Names)
+	}
+	if flag.Min != nil || flag.Max != nil {
+		err := r.updateFlagBounds(&flag)
+		if err != nil {
+			return err
+		}
+	}
 	if flag.Default != nil {
 		err := r.updateFlagDefault(&flag, flag.Default)
 		if err != nil {
@@ -132,8 +469,17 @@ func (r register) registerFlag(parent, set *FlagSet, flag Flag) error {
 			return err
 		}
 	}
+	if flag.DenySelects != nil {
+		err := r.updateFlagDenySelects(&flag, flag.DenySelects)
+		if err != nil {
+			return err
+		}
+	}
 
-	ns, names := r.cleanFlagNames(flag.Names)
+	ns, names, err := r.splitHiddenNames(flag.Names)
+	if err != nil {
+		return err
+	}
 	if names != flagNamePositional {
 		for _, s := range ns {
 			if s == flagNamePositional {
@@ -141,25 +487,54 @@ func (r register) registerFlag(parent, set *FlagSet, flag Flag) error {
 			}
 		}
 	}
-	if duplicates := r.findDuplicates(parent, set, ns); len(duplicates) > 0 {
-		if parent != nil {
-			return newErrorf(errDuplicateFlagRegister, "duplicate flags with parent/self/children: %s->%s, %v", parent.self.Names, set.self.Names, duplicates)
-		}
-		return newErrorf(errDuplicateFlagRegister, "duplicate flags with self/children: %s, %v", set.self.Names, duplicates)
+	if err := r.duplicateError(parent, set, ns, false); err != nil {
+		return err
+	}
+	if name, conflict := r.findBundleConflict(set, ns); name != "" {
+		return newErrorf(errDuplicateFlagRegister, "ambiguous short flag bundle: %s.%s conflicts with %s", set.self.Names, name, conflict)
 	}
 
 	flag.Names = names
+	flag.tolerance = set.floatTolerance
+	if flag.ValSep == "" {
+		flag.ValSep = set.valSep()
+	}
 	r.cleanFlag(&flag)
+	r.updateFlagUsage(&flag, flag.Usage)
 
 	set.flags = append(set.flags, flag)
 	r.addIndexes(set.flagIndexes, ns, len(set.flags)-1)
 	return nil
 }
 
+// splitHiddenNames clean flag names and remove the hidden alias mark '~', hidden aliases are
+// registered for lookup but excluded from the returned display names.
+func (r register) splitHiddenNames(names string) (all []string, visible string, err error) {
+	var display []string
+	for _, name := range splitAndTrimSpace(names, flagNameSeparatorForSplit) {
+		if strings.HasPrefix(name, flagNameHiddenMark) {
+			name = strings.TrimSpace(name[len(flagNameHiddenMark):])
+		} else {
+			display = append(display, name)
+		}
+		all = append(all, name)
+	}
+	if len(display) == 0 && len(all) > 0 {
+		return nil, "", newErrorf(errInvalidNames, "flag must have non-hidden name: %s", names)
+	}
+	return all, r.joinFlagNames(display), nil
+}
+
 func (r register) checkSubsetValid(flag *Flag) error {
 	if flag.Names == "" {
 		return newErrorf(errInvalidNames, "subset names should not be empty")
 	}
+	if flag.Raw && flag.argsPtr() == nil {
+		return newErrorf(errInvalidStructure, "raw subset must has args pointer: %s", flag.Names)
+	}
+	if ptr := flag.ArgsTypedPtr; ptr != nil && (!kindOf(ptr).IsSlice() || isOptionalPtr(ptr)) {
+		return newErrorf(errInvalidType, "invalid args pointer type of %s, expect pointer of slice of supported types", flag.Names)
+	}
 	return nil
 }
 
@@ -175,43 +550,98 @@ func (r register) registerSet(parent, set *FlagSet, flag Flag) (*FlagSet, error)
 		return nil, err
 	}
 
-	if duplicates := r.findDuplicates(parent, set, ns); len(duplicates) > 0 {
-		return nil, newErrorf(errDuplicateFlagRegister, "duplicate subset name: %v", duplicates)
+	if err := r.duplicateError(parent, set, ns, true); err != nil {
+		return nil, err
 	}
 
 	child := newFlagSet(flag)
 	child.self.Default = false
 	child.errorHandling = set.errorHandling
+	for errType, code := range set.exitCodes {
+		child.setExitCode(errType, code)
+	}
+	child.helpTemplate = set.helpTemplate
+	child.helpWidth = set.helpWidth
+	child.helpLabels = set.helpLabels
+	child.strictTags = set.strictTags
+	child.ignoredTags = set.ignoredTags
+	child.expandEnv = set.expandEnv
+	child.noBundling = set.noBundling
+	child.printUsageOnError = set.printUsageOnError
+	child.suggestCommands = set.suggestCommands
+	child.suggestFlags = set.suggestFlags
+	child.allowExtraArgs = set.allowExtraArgs
+	child.floatTolerance = set.floatTolerance
+	child.ignoreUnknown = set.ignoreUnknown
+	child.namingStyle = set.namingStyle
+	child.globalFlags = set.globalFlags
+	if set.defaultValSep != "" {
+		child.DefaultValSep(set.defaultValSep)
+	}
+	child.bound = set.bound
+	if ptr := child.self.argsPtr(); ptr != nil {
+		if names, has := child.bound.bind(ptr, child.self.Names); has {
+			return nil, newErrorf(errDuplicateFlagRegister, "args pointer of %s is already bound to %s", child.self.Names, names)
+		}
+	}
+	child.parentPath = append(append([]string(nil), set.parentPath...), set.self.Names)
 
 	set.subsets = append(set.subsets, *child)
 	r.addIndexes(set.subsetIndexes, ns, len(set.subsets)-1)
 	return &set.subsets[len(set.subsets)-1], nil
 }
 
+// registerEnableFlags register flag names to parent which enable the subset like it's names,
+// flags following them are searched from the subset first.
+func (r register) registerEnableFlags(parent, set *FlagSet, names string) error {
+	if parent == nil {
+		return newErrorf(errInvalidNames, "enable flag names is not allowed for root: %s", names)
+	}
+	ns, _ := r.cleanFlagNames(names)
+	for _, name := range ns {
+		if !strings.HasPrefix(name, "-") || name == "-" {
+			return newErrorf(errInvalidNames, "invalid enable flag name: %s.%s", set.self.Names, name)
+		}
+	}
+	if err := r.duplicateError(nil, parent, ns, true); err != nil {
+		return err
+	}
+	first, _ := r.cleanFlagNames(set.self.Names)
+	r.addIndexes(parent.subsetIndexes, ns, parent.subsetIndexes[first[0]])
+	return nil
+}
+
+type boundKey struct {
+	typ  reflect.Type
+	addr uintptr
+}
+
+// boundPtrs record pointers bound to flagset tree and the names of flagset, it's used to detect
+// re-registration of structure and aliasing of args field. Pointers are distinguished with type
+// because a structure and it's first field share the same address.
+type boundPtrs map[boundKey]string
+
+func (b boundPtrs) bind(ptr interface{}, names string) (string, bool) {
+	refval := reflect.ValueOf(ptr)
+	key := boundKey{typ: refval.Type(), addr: refval.Pointer()}
+	if prev, has := b[key]; has {
+		return prev, true
+	}
+	b[key] = names
+	return "", false
+}
+
+var noFlagType = reflect.TypeOf((*NoFlag)(nil)).Elem()
+
 func (r register) registerStructure(parent, set *FlagSet, st interface{}) error {
 	// parent is used to checking duplicate flags and indicate that subset must has a 'Enable' field
-	const (
-		tagNames   = "names"
-		tagArglist = "arglist"
-		tagUsage   = "usage"
-		tagDesc    = "desc"
-		tagVersion = "version"
-
-		tagEnv          = "env"
-		tagValsep       = "valsep"
-		tagDefault      = "default"
-		tagSelects      = "selects"
-		tagArgs         = "args"
-		tagArgsAnywhere = "argsAnywhere"
-
-		fieldSubsetEnable = "Enable"
-		fieldArgs         = "Args"
-	)
-
 	refval := reflect.ValueOf(st)
 	if refval.Kind() != reflect.Ptr || refval.Elem().Kind() != reflect.Struct {
 		return newErrorf(errNonPointer, "not pointer of structure")
 	}
+	if names, has := set.bound.bind(st, set.self.Names); has {
+		return newErrorf(errInvalidStructure, "structure %s is already registered to %s", refval.Type(), names)
+	}
 
 	var (
 		parseQueue = []reflect.Value{refval.Elem()}
@@ -226,39 +656,62 @@ func (r register) registerStructure(parent, set *FlagSet, st interface{}) error
 		copy(parseQueue, parseQueue[1:])
 		parseQueue = parseQueue[:l-1]
 
-		reftyp := refval.Type()
-		numfield := refval.NumField()
-		for i := 0; i < numfield; i++ {
-			field := reftyp.Field(i)
-			if !ast.IsExported(field.Name) {
+		for i, sf := range cachedStructFields(refval.Type()) {
+			field := sf.field
+			if !sf.exported {
+				// exported fields of unexported embedded structure are promoted and accessible
+				if field.Anonymous && field.Type.Kind() == reflect.Struct && refval.Field(i).CanAddr() &&
+					!reflect.PtrTo(field.Type).Implements(noFlagType) {
+					parseQueue = append(parseQueue, refval.Field(i))
+				}
 				continue
 			}
+			tags, err := r.parseFieldTags(set, sf)
+			if err != nil {
+				return err
+			}
 
 			fieldVal := refval.Field(i)
+			ptr := fieldVal.Addr().Interface()
 
-			args := field.Tag.Get(tagArgs)
+			args := tags.Get(tagArgs)
 			isArgs, err := parseBool(args, "false")
 			if err != nil {
 				return newErrorf(errInvalidValue, "non-bool tag args value: %s.%s %s", set.self.Names, field.Name, args)
 			}
 			if field.Name == fieldArgs || isArgs {
-				argsAnywhere := field.Tag.Get(tagArgsAnywhere)
+				argsAnywhere := tags.Get(tagArgsAnywhere)
 				anywhere, err := parseBool(argsAnywhere, "false")
 				if err != nil {
 					return newErrorf(errInvalidValue, "non-bool tag anywhere value: %s.%s %s", set.self.Names, field.Name, argsAnywhere)
 				}
-				if set.self.ArgsPtr != nil {
+				if set.self.argsPtr() != nil {
 					return newErrorf(errDuplicateFlagRegister, "duplicate args field: %s", set.self.Names)
 				}
-				if _, ok := fieldVal.Interface().([]string); !ok {
-					return newErrorf(errInvalidType, "invalid %s:Args field type, expect []string", set.self.Names)
+				if names, has := set.bound.bind(ptr, set.self.Names); has {
+					return newErrorf(errDuplicateFlagRegister, "args field %s.%s is already bound to %s", set.self.Names, field.Name, names)
+				}
+				if !kindOf(ptr).IsSlice() || isOptionalPtr(ptr) {
+					return newErrorf(errInvalidType, "invalid %s:Args field type, expect slice of supported types", set.self.Names)
+				}
+				if p, ok := ptr.(*[]string); ok {
+					set.self.ArgsPtr = p
+				} else {
+					set.self.ArgsTypedPtr = ptr
 				}
-				set.self.ArgsPtr = fieldVal.Addr().Interface().(*[]string)
 				set.self.ArgsAnywhere = anywhere
+				if valsep := r.parseValSep(tags.Get(tagValsep)); valsep != "" {
+					set.self.ValSep = valsep
+				} else {
+					set.self.ValSep = set.valSep()
+				}
+				set.self.ArgsEnv = tags.Get(tagEnv)
+				if def := tags.Get(tagArgsDefault); def != "" {
+					set.self.ArgsDefault = splitValues(def, set.self.ValSep)
+				}
 				continue
 			}
 
-			ptr := fieldVal.Addr().Interface()
 			if field.Name == fieldSubsetEnable {
 				if field.Type.Kind() != reflect.Bool {
 					return newErrorf(errInvalidType, "illegal type of field '%s', expect bool", fieldSubsetEnable)
@@ -266,73 +719,168 @@ func (r register) registerStructure(parent, set *FlagSet, st interface{}) error
 				if set.self.Ptr == nil {
 					set.self.Ptr = ptr
 				}
+				if names := tags.Get(tagNames); names != "" {
+					err = r.registerEnableFlags(parent, set, names)
+					if err != nil {
+						return err
+					}
+				}
 				continue
 			}
 
 			var (
-				names   = field.Tag.Get(tagNames)
-				usage   = field.Tag.Get(tagUsage)
-				desc    = field.Tag.Get(tagDesc)
-				version = field.Tag.Get(tagVersion)
-				arglist = field.Tag.Get(tagArglist)
+				names   = tags.Get(tagNames)
+				usage   = tags.Get(tagUsage)
+				desc    = tags.Get(tagDesc)
+				version = tags.Get(tagVersion)
+				arglist = tags.Get(tagArglist)
 			)
 			if names == "-" {
 				continue
 			}
+			if env := tags.Get(tagEnableIf); env != "" {
+				// experimental field is registered only if the environment variable is truthy
+				if val, err := parsePossibleBoolValue(envParser(env)); err != nil || val != "true" {
+					continue
+				}
+			}
 			_, ok := ptr.(NoFlag)
 			if ok {
 				continue
 			}
+			var repeatable bool
+			err = r.parseBoolTags(set, field, tags, map[string]*bool{tagRepeatable: &repeatable})
+			if err != nil {
+				return err
+			}
+			if repeatable {
+				if fieldVal.Kind() != reflect.Slice || field.Type.Elem().Kind() != reflect.Struct {
+					return newErrorf(errInvalidType, "repeatable subset should be slice of structure: %s.%s", set.self.Names, field.Name)
+				}
+				if names == "" {
+					names = styledName(set.namingStyle, field.Name)
+				}
+				child, err := r.registerSet(parent, set, Flag{
+					Names:   names,
+					Arglist: arglist,
+					Usage:   usage,
+					Desc:    desc,
+					Version: version,
+				})
+				if err != nil {
+					return err
+				}
+				// flags are bound to a scratch element, it's appended to the slice after each occurrence is resolved
+				child.repeatSlice, child.repeatElem = ptr, reflect.New(field.Type.Elem()).Interface()
+				err = r.registerStructure(set, child, child.repeatElem)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if field.Anonymous && typeName(ptr) == "" {
+				// only embedded structures are flattened, others such as interfaces are ignored
+				if fieldVal.Kind() == reflect.Struct {
+					parseQueue = append(parseQueue, fieldVal)
+				}
+				continue
+			}
 
-			if fieldVal.Kind() != reflect.Struct {
+			if fieldVal.Kind() != reflect.Struct || typeName(ptr) != "" {
 				var (
-					env     = field.Tag.Get(tagEnv)
-					def     = field.Tag.Get(tagDefault)
-					valsep  = field.Tag.Get(tagValsep)
-					selects = field.Tag.Get(tagSelects)
+					env     = tags.Get(tagEnv)
+					def     = tags.Get(tagDefault)
+					valsep  = r.parseValSep(tags.Get(tagValsep))
+					selects = tags.Get(tagSelects)
+					deny    = tags.Get(tagDenySelects)
+					layout  = tags.Get(tagLayout)
 				)
 				if names == "" {
-					names = "-" + unexportedName(field.Name)
+					names = styledFlagName(set.namingStyle, field.Name)
 				} else if names == flagNamePositional {
 					if arglist == "" {
 						arglist = field.Name
 					}
 				}
 				if valsep == "" {
-					valsep = ","
+					valsep = set.valSep()
 				}
 				if typeName(ptr) == "" {
 					continue
 				}
-				defVal, err := parseDefault(def, valsep, ptr)
-				if err != nil {
-					return err
-				}
-				selectsVal, err := parseSelectsString(selects, valsep, ptr)
-				if err != nil {
-					return err
-				}
-				err = r.registerFlag(parent, set, Flag{
+				flag := Flag{
 					Names:   names,
 					Arglist: arglist,
 					Usage:   usage,
 					Desc:    desc,
 					Version: version,
 
-					Ptr:     ptr,
-					Env:     env,
-					ValSep:  valsep,
-					Default: defVal,
-					Selects: selectsVal,
+					Ptr:    ptr,
+					Env:    env,
+					ValSep: valsep,
+					Layout: layout,
+				}
+				err = r.parseBoolTags(set, field, tags, map[string]*bool{
+					tagRaw:        &flag.Raw,
+					tagSelectsCI:  &flag.SelectsCI,
+					tagAttachOnly: &flag.AttachOnly,
+					tagSplit:      &flag.Split,
+					tagRequired:   &flag.Required,
+					tagClearable:  &flag.Clearable,
+					tagBoolValue:  &flag.BoolValue,
+					tagEnvOnly:    &flag.EnvOnly,
+					tagSecret:     &flag.Secret,
+					tagRune:       &flag.Rune,
+					tagPercent:    &flag.Percent,
+					tagInvert:     &flag.Invert,
 				})
 				if err != nil {
 					return err
 				}
-			} else if field.Anonymous {
-				parseQueue = append(parseQueue, fieldVal)
+				if nargs := tags.Get(tagNargs); nargs != "" {
+					flag.Nargs, err = strconv.Atoi(nargs)
+					if err != nil || flag.Nargs <= 0 {
+						return newErrorf(errInvalidValue, "invalid tag nargs value: %s.%s %s", set.self.Names, field.Name, nargs)
+					}
+				}
+				if min := tags.Get(tagMin); min != "" {
+					flag.Min = min
+				}
+				if max := tags.Get(tagMax); max != "" {
+					flag.Max = max
+				}
+				flag.Default, err = parseDefault(&flag, def)
+				if err != nil {
+					return err
+				}
+				flag.Selects, err = parseSelectsString(&flag, selects)
+				if err != nil {
+					return err
+				}
+				flag.DenySelects, err = parseSelectsString(&flag, deny)
+				if err != nil {
+					return err
+				}
+				err = r.registerFlag(parent, set, flag)
+				if err != nil {
+					return err
+				}
+				if requires := tags.Get(tagRequires); requires != "" {
+					// the first visible name of registered flag, names of field may begin with hidden alias
+					ns, _ := r.cleanFlagNames(set.flags[len(set.flags)-1].Names)
+					set.groups = append(set.groups, flagGroup{
+						kind:  groupRequires,
+						names: append([]string{ns[0]}, splitAndTrimSpace(requires, ",")...),
+					})
+				}
 			} else {
 				if names == "" {
-					names = unexportedName(field.Name)
+					names = styledName(set.namingStyle, field.Name)
+				}
+				var raw bool
+				err = r.parseBoolTags(set, field, tags, map[string]*bool{tagRaw: &raw})
+				if err != nil {
+					return err
 				}
 				child, err := r.registerSet(parent, set, Flag{
 					Names:   names,
@@ -348,12 +896,21 @@ func (r register) registerStructure(parent, set *FlagSet, st interface{}) error
 				if err != nil {
 					return err
 				}
+				if raw {
+					if child.self.argsPtr() == nil {
+						return newErrorf(errInvalidStructure, "raw subset must has args field: %s.%s", set.self.Names, field.Name)
+					}
+					child.self.Raw = true
+				}
 			}
 		}
 		if md, ok := st.(Metadata); ok {
 			metadatas = append(metadatas, md)
 		}
 	}
+	if fz, ok := st.(Finalizer); ok {
+		set.finalizer = fz
+	}
 	if parent != nil && set.self.Ptr == nil {
 		return newErrorf(errInvalidStructure, "child structure must has a 'Enable' field")
 	}
@@ -365,8 +922,47 @@ func (r register) registerStructure(parent, set *FlagSet, st interface{}) error
 			}
 		}
 	}
+	// flags declared by requires tag may be registered by later fields, they are checked after all
+	// fields are registered.
+	for _, group := range set.groups {
+		if _, err := set.groupFlags(group.names); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+func (r register) parseFieldTags(set *FlagSet, sf structField) (fieldTags, error) {
+	field := sf.field
+	if set.strictTags {
+		for _, key := range sf.tagKeys {
+			if key != tagFlag && !stringsContains(knownTags, key) && !stringsContains(set.ignoredTags, key) {
+				return sf.tags, newErrorf(errInvalidValue, "unknown tag %s: %s.%s", key, set.self.Names, field.Name)
+			}
+		}
+		for _, key := range sf.flagKeys {
+			if !stringsContains(knownTags, key) {
+				return sf.tags, newErrorf(errInvalidValue, "unknown tag %s: %s.%s", key, set.self.Names, field.Name)
+			}
+		}
+	}
+	if sf.invalid != "" {
+		return sf.tags, newErrorf(errInvalidValue, "invalid flag tag section %s: %s.%s", sf.invalid, set.self.Names, field.Name)
+	}
+	return sf.tags, nil
+}
+
+func (r register) parseBoolTags(set *FlagSet, field reflect.StructField, tags fieldTags, ptrs map[string]*bool) error {
+	for key, ptr := range ptrs {
+		val := tags.Get(key)
+		b, err := parseBool(val, "false")
+		if err != nil {
+			return newErrorf(errInvalidValue, "non-bool tag %s value: %s.%s %s", key, set.self.Names, field.Name, val)
+		}
+		*ptr = b
+	}
+	return nil
+}
+
 func (r register) registerFlagsIfNotDuplicated(parent, set *FlagSet, names []string, ptr interface{}, usage string) (bool, error) {
 	if len(names) == 0 {
 		return false, nil
@@ -374,6 +970,9 @@ func (r register) registerFlagsIfNotDuplicated(parent, set *FlagSet, names []str
 	if duplicates := r.findDuplicates(parent, set, names); len(duplicates) > 0 {
 		return false, nil
 	}
+	if name, _ := r.findBundleConflict(set, names); name != "" {
+		return false, nil
+	}
 	err := r.registerFlag(parent, set, Flag{
 		Ptr:   ptr,
 		Names: strings.Join(names, ","),
@@ -431,6 +1030,25 @@ func (r register) updateFlagDesc(flag *Flag, desc string) {
 	flag.descLines = r.splitLines(flag.Desc)
 }
 
+// updateFlagUsage strip the first back-quoted word in usage, it's used as the argument name of flag
+// if arglist is empty, e.g. "set the output `file`".
+func (r register) updateFlagUsage(flag *Flag, usage string) {
+	flag.Usage = usage
+	start := strings.Index(usage, "`")
+	if start < 0 {
+		return
+	}
+	end := strings.Index(usage[start+1:], "`")
+	if end < 0 {
+		return
+	}
+	end += start + 1
+	if flag.Arglist == "" {
+		flag.Arglist = usage[start+1 : end]
+	}
+	flag.Usage = usage[:start] + usage[start+1:end] + usage[end+1:]
+}
+
 func (r register) updateFlagVersion(flag *Flag, version string) {
 	flag.Version = version
 	flag.versionLines = r.splitLines(flag.Version)
@@ -480,7 +1098,25 @@ func (r register) updateMeta(set *FlagSet, children string, meta Flag) error {
 		flag.Arglist = meta.Arglist
 	}
 	if meta.Usage != "" {
-		flag.Usage = meta.Usage
+		if flag == &subset.self {
+			flag.Usage = meta.Usage
+		} else {
+			r.updateFlagUsage(flag, meta.Usage)
+		}
+	}
+	if meta.Min != nil || meta.Max != nil {
+		tmp := *flag
+		if meta.Min != nil {
+			tmp.Min = meta.Min
+		}
+		if meta.Max != nil {
+			tmp.Max = meta.Max
+		}
+		err = r.updateFlagBounds(&tmp)
+		if err != nil {
+			return err
+		}
+		flag.Min, flag.Max = tmp.Min, tmp.Max
 	}
 	if meta.Default != nil {
 		err = r.updateFlagDefault(flag, meta.Default)
@@ -494,9 +1130,21 @@ func (r register) updateMeta(set *FlagSet, children string, meta Flag) error {
 			return err
 		}
 	}
+	if meta.DenySelects != nil {
+		err = r.updateFlagDenySelects(flag, meta.DenySelects)
+		if err != nil {
+			return err
+		}
+	}
 	if meta.Env != "" {
 		flag.Env = meta.Env
 	}
+	if meta.Layout != "" {
+		flag.Layout = meta.Layout
+	}
+	if meta.DefaultFunc != nil {
+		flag.DefaultFunc = meta.DefaultFunc
+	}
 	r.cleanFlag(flag)
 	return nil
 }
@@ -1,26 +1,53 @@
 package flag
 
 import (
-	"fmt"
+	"context"
 	"os"
 	"reflect"
+	"strings"
 )
 
 var envParser = os.Getenv
 
 type resolver struct {
-	LastSet *FlagSet
+	LastSet  *FlagSet
+	LastPath []string
+	ErrSet   *FlagSet // the flagset where error occurred
+
+	expandEnv bool        // expand environment variables of values for current resolving set
+	ranks     sourceRanks // precedence of environment and command line
+	help      *helpFlagValues
+	ctx       context.Context // nil means no cancellation
+
+	unknownFlags []string // unknown flags ignored by sets enabled IgnoreUnknown
+	unknownArgs  []string // non-flag values not accepted by sets enabled IgnoreUnknown
+	argsStart    int      // index of the first non-flag value consumed as positional flag or args, -1 if not found
+}
+
+func (r *resolver) expandVal(f *Flag, val string) string {
+	if !r.expandEnv || FlagKind(f).Elem() != KindString || f.Raw {
+		return val
+	}
+	return os.Expand(val, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		return envParser(name)
+	})
 }
 
 func (r *resolver) fromDefault(f *Flag) []string {
-	if !isSlicePtr(f.Ptr) {
-		return []string{fmt.Sprint(f.Default)}
+	if !FlagKind(f).IsSlice() {
+		return []string{formatValue(f, f.Default)}
 	}
 
 	refval := reflect.ValueOf(f.Default)
+	if refval.Kind() == reflect.Map {
+		return setKeys(refval)
+	}
 	vals := make([]string, 0, refval.Len())
 	for i, l := 0, refval.Len(); i < l; i++ {
-		val := fmt.Sprint(refval.Index(i).Interface())
+		val := formatValue(f, refval.Index(i).Interface())
 		if val != "" {
 			vals = append(vals, val)
 		}
@@ -28,6 +55,19 @@ func (r *resolver) fromDefault(f *Flag) []string {
 	return vals
 }
 
+func (r *resolver) fromDefaultFunc(f *Flag) ([]string, error) {
+	def := f.DefaultFunc()
+	if def == nil {
+		return nil, nil
+	}
+	flag := *f
+	err := defaultRegister.updateFlagDefault(&flag, def)
+	if err != nil {
+		return nil, err
+	}
+	return r.fromDefault(&flag), nil
+}
+
 func (r *resolver) fromEnv(f *Flag) []string {
 	val := envParser(f.Env)
 	if val == "" {
@@ -35,8 +75,8 @@ func (r *resolver) fromEnv(f *Flag) []string {
 	}
 
 	var vals []string
-	if isSlicePtr(f.Ptr) {
-		vals = splitAndTrimSpace(val, f.ValSep)
+	if FlagKind(f).IsSlice() {
+		vals = splitValues(val, f.ValSep)
 	} else {
 		vals = []string{val}
 	}
@@ -44,8 +84,11 @@ func (r *resolver) fromEnv(f *Flag) []string {
 }
 
 func (r *resolver) applyVals(f *Flag, vals ...string) error {
+	if len(vals) > 1 && FlagKind(f).IsSlice() {
+		growSlice(f.Ptr, len(vals))
+	}
 	for _, val := range vals {
-		err := applyValToPtr(f.Names, f.Ptr, val, f.Selects)
+		err := applyValToPtr(f, r.expandVal(f, val))
 		if err != nil {
 			return err
 		}
@@ -53,30 +96,159 @@ func (r *resolver) applyVals(f *Flag, vals ...string) error {
 	return nil
 }
 
+func (r *resolver) applyArgsEnvAndDefault(f *FlagSet) error {
+	ptr := f.self.argsPtr()
+	if ptr == nil || reflect.ValueOf(ptr).Elem().Len() != 0 {
+		return nil
+	}
+
+	var (
+		args    []string
+		errType = errInvalidValue
+	)
+	if f.self.ArgsEnv != "" && r.ranks.env > 0 {
+		args = splitValues(envParser(f.self.ArgsEnv), f.self.ValSep)
+	}
+	if len(args) == 0 {
+		args = f.self.ArgsDefault
+		errType = errInvalidDefault
+	}
+	for _, arg := range args {
+		err := applyValToPtr(&Flag{Names: fieldArgs, Ptr: ptr}, arg)
+		if err != nil {
+			return newErrorf(errType, "invalid non-flag value: %v.%s, %s", f.self.Names, arg, err.Error())
+		}
+	}
+	return nil
+}
+
 func (r *resolver) applyEnvAndDefault(f *FlagSet, applied map[*Flag]bool) error {
+	var refs map[*Flag]string
+	err := r.applyArgsEnvAndDefault(f)
+	if err != nil {
+		return err
+	}
 	for i := range f.flags {
+		if r.ctx != nil && r.ctx.Err() != nil {
+			return r.ctx.Err()
+		}
 		flag := &f.flags[i]
+		var (
+			vals []string
+			rank int
+		)
 		if applied[flag] {
-			continue
+			if flag.source != sourceCommandLine {
+				continue
+			}
+			rank = r.ranks.args
 		}
 		applied[flag] = true
 
-		var vals []string
-		if flag.Env != "" {
-			vals = r.fromEnv(flag)
+		source := flag.source
+		if flag.Env != "" && r.ranks.env > rank {
+			if envVals := r.fromEnv(flag); len(envVals) != 0 {
+				vals, rank, source = envVals, r.ranks.env, sourceEnv
+			}
+		}
+		if len(flag.configVals) != 0 && flag.configRank > rank {
+			vals, source = flag.configVals, sourceConfig
+		}
+		if flag.source == sourceCommandLine {
+			if len(vals) == 0 {
+				continue
+			}
+			// overridden by later source
+			resetPtrVal(flag.Ptr)
 		}
+		flag.source = source
 		if len(vals) == 0 && flag.Default != nil {
+			flag.source = sourceDefault
+			if def, ok := flag.Default.(string); ok && hasFlagRef(def) {
+				// resolved after other flags
+				if refs == nil {
+					refs = make(map[*Flag]string)
+				}
+				refs[flag] = def
+				continue
+			}
 			vals = r.fromDefault(flag)
 		}
+		if len(vals) == 0 && flag.Default == nil && flag.DefaultFunc != nil {
+			flag.source = sourceDefault
+			var err error
+			vals, err = r.fromDefaultFunc(flag)
+			if err != nil {
+				return err
+			}
+		}
 		err := r.applyVals(flag, vals...)
 		if err != nil {
 			return err
 		}
 	}
+	if len(refs) == 0 {
+		return nil
+	}
+
+	resolving := make(map[*Flag]bool)
+	for i := range f.flags {
+		flag := &f.flags[i]
+		if _, has := refs[flag]; has {
+			err := r.applyDefaultRef(f, flag, refs, resolving, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
+func (r *resolver) applyDefaultRef(f *FlagSet, flag *Flag, refs map[*Flag]string, resolving map[*Flag]bool, chain []string) error {
+	def, has := refs[flag]
+	if !has {
+		return nil
+	}
+	chain = append(chain, flag.Names)
+	if resolving[flag] {
+		return newErrorf(errInvalidDefault, "cyclic default value reference: %s", strings.Join(chain, " -> "))
+	}
+	resolving[flag] = true
+
+	val, err := expandFlagRefs(def, func(name string) (string, error) {
+		ref := f.searchFlagRef(name)
+		if ref == nil && r.expandEnv {
+			// leave it to environment expanding
+			return "${" + name + "}", nil
+		}
+		if ref == nil {
+			return "", newErrorf(errFlagNotFound, "default value of %s references unknown flag: %s", flag.Names, name)
+		}
+		err := r.applyDefaultRef(f, ref, refs, resolving, chain)
+		if err != nil {
+			return "", err
+		}
+		val := formatPtrValue(ref)
+		if r.expandEnv {
+			// referenced value has been expanded
+			val = strings.Replace(val, "$", "$$", -1)
+		}
+		return val, nil
+	})
+	if err != nil {
+		return err
+	}
+	delete(refs, flag)
+
+	vals := []string{val}
+	if FlagKind(flag).IsSlice() {
+		vals = splitValues(val, flag.ValSep)
+	}
+	return r.applyVals(flag, vals...)
+}
+
 func (r *resolver) resolveFlags(f *FlagSet, context []string, args []argument) error {
+	r.expandEnv = f.expandEnv
 	var positional []*Flag
 	for i := range f.flags {
 		if f.flags[i].Names == flagNamePositional {
@@ -89,14 +261,25 @@ func (r *resolver) resolveFlags(f *FlagSet, context []string, args []argument) e
 		err     error
 
 		positionalIndex int
+		nonFlagFound    bool
+		remain          int      // count of values still needed by nargs flag
+		extraArgs       []string // non-flag values not accepted by command
+		boolFlag        *Flag    // last bool flag which could take next bool literal
 		applyValue      = func(flag *Flag, val string) error {
 			applied[flag] = true
+			flag.source = sourceCommandLine
+			if flag.Split && FlagKind(flag).IsSlice() {
+				return r.applyVals(flag, splitValues(val, flag.ValSep)...)
+			}
 			return r.applyVals(flag, val)
 		}
 		applyLastFlag = func() error {
 			if flag == nil {
 				return nil
 			}
+			if flag.Nargs > 1 {
+				return newErrorf(errFlagValueNotProvided, "flag requires %d values, %d provided: %v.%s", flag.Nargs, flag.Nargs-remain, context, flag.Names)
+			}
 			return newErrorf(errFlagValueNotProvided, "flag value is not provided: %v.%s", context, flag.Names)
 		}
 		hasFlag = func(args []argument) bool {
@@ -108,10 +291,17 @@ func (r *resolver) resolveFlags(f *FlagSet, context []string, args []argument) e
 			return false
 		}
 		appendNonFlagArg = func(arg argument, args []argument) error {
-			if (positionalIndex >= len(positional) && f.self.ArgsPtr == nil) ||
-				(!f.self.ArgsAnywhere && hasFlag(args[1:])) {
+			if positionalIndex >= len(positional) && f.self.argsPtr() == nil {
+				// collected to report all of them
+				extraArgs = append(extraArgs, arg.Value)
+				return nil
+			}
+			if !f.self.ArgsAnywhere && hasFlag(args[1:]) {
 				return newErrorf(errNonFlagValue, "unexpected non-flag value: %v %s", context, arg.Value)
 			}
+			if r.argsStart < 0 || arg.Index < r.argsStart {
+				r.argsStart = arg.Index
+			}
 			if positionalIndex < len(positional) {
 				err = applyValue(positional[positionalIndex], arg.Value)
 				if err != nil {
@@ -121,14 +311,27 @@ func (r *resolver) resolveFlags(f *FlagSet, context []string, args []argument) e
 				return nil
 			}
 
-			slice := *f.self.ArgsPtr
-			slice = append(slice, arg.Value)
-			*f.self.ArgsPtr = slice
+			err = applyValToPtr(&Flag{Names: fieldArgs, Ptr: f.self.argsPtr()}, arg.Value)
+			if err != nil {
+				return newErrorf(errInvalidValue, "invalid non-flag value: %v %s, %s", context, arg.Value, err.Error())
+			}
 			return nil
 		}
 	)
 
 	for i, arg := range args {
+		if boolFlag != nil {
+			// bool flag accepting value could take next literal 'true' or 'false'
+			last := boolFlag
+			boolFlag = nil
+			if arg.Type == argumentValue && isBoolLiteral(arg.Value) {
+				err = applyValue(last, arg.Value)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
 		switch arg.Type {
 		case argumentFlag:
 			err = applyLastFlag()
@@ -137,16 +340,65 @@ func (r *resolver) resolveFlags(f *FlagSet, context []string, args []argument) e
 			}
 
 			flag = f.searchFlag(arg.Value)
+			if flag == nil && f.ignoreUnknown {
+				token := arg.Value
+				if arg.AttachValid {
+					token += "=" + arg.Attached
+				}
+				r.unknownFlags = append(r.unknownFlags, token)
+				continue
+			}
 			if flag == nil {
-				return newErrorf(errFlagNotFound, "unsupported flag: %v.%s", context, arg.Value)
+				var hint string
+				if f.suggestFlags {
+					hint = suggestion(arg.Value, f.flagIndexes)
+				}
+				return newErrorf(errFlagNotFound, "unsupported flag: %v.%s%s", context, arg.Value, hint)
+			}
+			if flag.EnvOnly {
+				return newErrorf(errFlagNotFound, "flag could only be set by environment %s: %v.%s", flag.Env, context, arg.Value)
 			}
-			if applied[flag] && !isSlicePtr(flag.Ptr) {
+			if applied[flag] && !FlagKind(flag).IsSlice() {
 				return newErrorf(errDuplicateFlagParsed, "duplicated flag: %v.%s", context, flag.Names)
 			}
+			if arg.Cluster != "" && !isBoolPtr(flag.Ptr) {
+				return newErrorf(errFlagValueNotProvided, "flag value is not provided: %v.%s, only the last flag of cluster %s could take value", context, arg.Value, arg.Cluster)
+			}
 
+			remain = flag.Nargs
+			if arg.AttachValid && r.help != nil && flag.Ptr == interface{}(&r.help.showHelp) {
+				if _, e := parsePossibleBoolValue(arg.Attached); e != nil {
+					// non-bool value of help flag is the subcommand path to show help for, e.g. '--help=remote.add'
+					applied[flag] = true
+					r.help.showHelp, r.help.children = true, arg.Attached
+					flag = nil
+					continue
+				}
+			}
 			if arg.AttachValid {
-				// directly consume flag attached value
-				err = applyValue(flag, arg.Attached)
+				// directly consume flag attached value, it's splitted for slice flag
+				vals := []string{arg.Attached}
+				if isAttachedSplittable(flag) && strings.Contains(arg.Attached, flag.ValSep) {
+					vals = splitValues(arg.Attached, flag.ValSep)
+				}
+				for _, val := range vals {
+					err = applyValue(flag, val)
+					if err != nil {
+						return err
+					}
+				}
+				remain--
+				if remain <= 0 {
+					flag = nil
+				}
+			} else if flag.AttachOnly {
+				// attach-only flag should not consume next value, default value is used if exists
+				if flag.Default == nil {
+					return newErrorf(errFlagValueNotProvided, "flag value must be attached by '=': %v.%s", context, flag.Names)
+				}
+				applied[flag] = true
+				flag.source = sourceDefault
+				err = r.applyVals(flag, r.fromDefault(flag)...)
 				if err != nil {
 					return err
 				}
@@ -157,10 +409,17 @@ func (r *resolver) resolveFlags(f *FlagSet, context []string, args []argument) e
 				if err != nil {
 					return err
 				}
+				if flag.BoolValue && !arg.AttachValid && arg.Cluster == "" {
+					boolFlag = flag
+				}
 				flag = nil
 			}
 		case argumentValue:
+			if flag == nil && f.suggestCommands && len(f.subsets) > 0 && !nonFlagFound {
+				return newErrorf(errUnknownCommand, "unknown command: %v.%s%s", context, arg.Value, suggestion(arg.Value, f.subsetIndexes))
+			}
 			if flag == nil {
+				nonFlagFound = true
 				err = appendNonFlagArg(args[i], args[i:])
 				if err != nil {
 					return err
@@ -170,7 +429,10 @@ func (r *resolver) resolveFlags(f *FlagSet, context []string, args []argument) e
 				if err != nil {
 					return err
 				}
-				flag = nil
+				remain--
+				if remain <= 0 {
+					flag = nil
+				}
 			}
 		default:
 			panic("unreachable")
@@ -180,6 +442,12 @@ func (r *resolver) resolveFlags(f *FlagSet, context []string, args []argument) e
 	if err != nil {
 		return err
 	}
+	if len(extraArgs) > 0 && f.ignoreUnknown {
+		r.unknownArgs = append(r.unknownArgs, extraArgs...)
+	} else if len(extraArgs) > 0 && !f.allowExtraArgs {
+		return newErrorf(errNonFlagValue, "command %s doesn't accept non-flag values, %d extra values: %s",
+			strings.Join(context, "."), len(extraArgs), strings.Join(extraArgs, " "))
+	}
 	//if positionalIndex < len(positional) {
 	//	var names []string
 	//	for i := positionalIndex; i < len(positional); i++ {
@@ -188,53 +456,107 @@ func (r *resolver) resolveFlags(f *FlagSet, context []string, args []argument) e
 	//	return newErrorf(errPositionalFlagNotProvided, "flag not provided: %v.%v", context, names)
 	//}
 
-	return r.applyEnvAndDefault(f, applied)
+	err = r.applyEnvAndDefault(f, applied)
+	if err != nil {
+		return err
+	}
+	if r.help != nil && (r.help.showHelp || r.help.showVersion) {
+		// constraints are not checked for help and version, they should be shown even if flags are missing
+		return nil
+	}
+	for i := range f.flags {
+		flag := &f.flags[i]
+		if flag.Required && !flag.isProvided() {
+			return newErrorf(errFlagValueNotProvided, "required flag is not provided: %v.%s", context, flag.Names)
+		}
+	}
+	return r.checkGroups(f, context)
 }
 
-func (r *resolver) resolveSet(f *FlagSet, context []string, args *scanArgs) (lastSubset *FlagSet, err error) {
+func (r *resolver) resolveSet(f *FlagSet, context []string, args *scanArgs) (lastSubset *FlagSet, lastPath []string, err error) {
+	if r.ctx != nil && r.ctx.Err() != nil {
+		r.ErrSet = f
+		return nil, nil, r.ctx.Err()
+	}
 	context = append(context, f.self.Names)
 	err = r.resolveFlags(f, context, args.Flags[1:])
 	if err != nil {
-		return nil, err
+		r.ErrSet = f
+		return nil, nil, err
 	}
 	for sub, subArgs := range args.Sets {
 		set := &f.subsets[f.subsetIndexes[sub]]
+		if set.repeatSlice != nil {
+			last, path, err := r.resolveRepeats(set, context, append(args.Repeats[sub], subArgs))
+			if err != nil {
+				return nil, nil, err
+			}
+			if sub == args.FirstSubset {
+				lastSubset = last
+				lastPath = append([]string{set.commandName()}, path...)
+			}
+			continue
+		}
 		err = r.applyVals(&set.self, "true")
 		if err != nil {
-			return nil, err
+			r.ErrSet = set
+			return nil, nil, err
 		}
 
-		last, err := r.resolveSet(set, context, subArgs)
+		last, path, err := r.resolveSet(set, context, subArgs)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if sub == args.FirstSubset {
 			lastSubset = last
-			if lastSubset == nil {
-				lastSubset = set
-			}
+			lastPath = append([]string{set.commandName()}, path...)
 		}
 	}
 
 	if lastSubset == nil {
 		lastSubset = f
 	}
-	return lastSubset, nil
+	return lastSubset, lastPath, nil
+}
+
+// resolveRepeats resolve each occurrence of repeatable subset to the scratch element and append it
+// to the slice, values of previous occurrence are reset before resolving.
+func (r *resolver) resolveRepeats(set *FlagSet, context []string, occurrences []*scanArgs) (lastSubset *FlagSet, lastPath []string, err error) {
+	slice := reflect.ValueOf(set.repeatSlice).Elem()
+	slice.Set(reflect.Zero(slice.Type()))
+	for _, occurrence := range occurrences {
+		r.reset(set)
+		err = r.applyVals(&set.self, "true")
+		if err != nil {
+			r.ErrSet = set
+			return nil, nil, err
+		}
+		lastSubset, lastPath, err = r.resolveSet(set, context, occurrence)
+		if err != nil {
+			return nil, nil, err
+		}
+		slice.Set(reflect.Append(slice, reflect.ValueOf(set.repeatElem).Elem()))
+	}
+	return lastSubset, lastPath, nil
 }
 
 func (r *resolver) resolve(f *FlagSet, args *scanArgs) error {
-	var err error
-	r.LastSet, err = r.resolveSet(f, nil, args)
+	var (
+		path []string
+		err  error
+	)
+	r.help = &f.help
+	r.LastSet, path, err = r.resolveSet(f, nil, args)
+	r.LastPath = append([]string{f.commandName()}, path...)
 	return err
 }
 
 func (r *resolver) reset(f *FlagSet) {
-	if f.self.ArgsPtr != nil {
-		*f.self.ArgsPtr = nil
-	}
+	resetPtrVal(f.self.argsPtr())
 	resetPtrVal(f.self.Ptr)
 	for i := range f.flags {
 		resetPtrVal(f.flags[i].Ptr)
+		f.flags[i].source = sourceNone
 	}
 	for i := range f.subsets {
 		r.reset(&f.subsets[i])
@@ -1,6 +1,7 @@
 package flag
 
 import (
+	"strconv"
 	"strings"
 )
 
@@ -20,22 +21,39 @@ type argument struct {
 	// it will affects later positional flag and non-flag value parsing.
 	AttachValid bool
 	Attached    string
+
+	// the original short flag cluster if the flag is splitted from it and is not the last one,
+	// only the last flag of cluster could take value.
+	Cluster string
+
+	Index int // index in the parsed arguments
 }
 
 type scanArgs struct {
 	Flags       []argument
 	FirstSubset string
 	Sets        map[string]*scanArgs
+	Repeats     map[string][]*scanArgs // previous occurrences of repeatable subsets, the last one is in Sets
 }
 
 type scanner struct {
 	SubsetStack []string
 	Result      scanArgs
+
+	// global flag of ancestor waiting for values, the values are appended to ancestor
+	globalStack  []string
+	globalValues int
+	// subset stack when help flag is scanned, help of the top subset is shown
+	HelpStack []string
 }
 
 func (s *scanner) appendArg(arg argument, isSubset bool) {
+	s.appendArgTo(s.SubsetStack, arg, isSubset)
+}
+
+func (s *scanner) appendArgTo(stack []string, arg argument, isSubset bool) {
 	curr := &s.Result
-	for _, subset := range s.SubsetStack {
+	for _, subset := range stack {
 		set := curr.Sets[subset]
 		if set == nil {
 			set = &scanArgs{}
@@ -44,7 +62,8 @@ func (s *scanner) appendArg(arg argument, isSubset bool) {
 			}
 			curr.Sets[subset] = set
 		}
-		if len(curr.Sets) == 1 {
+		if curr.FirstSubset == "" && !isEnableFlag(subset) {
+			// subset enabled by flag is not a subcommand
 			curr.FirstSubset = subset
 		}
 		curr = set
@@ -54,16 +73,42 @@ func (s *scanner) appendArg(arg argument, isSubset bool) {
 	}
 }
 
+// repeatSubset move the scanned occurrence of repeatable subset to Repeats, then the next occurrence
+// starts a new section.
+func (s *scanner) repeatSubset(stack []string, name string) {
+	curr := &s.Result
+	for _, subset := range stack {
+		curr = curr.Sets[subset]
+		if curr == nil {
+			return
+		}
+	}
+	if set := curr.Sets[name]; set != nil {
+		if curr.Repeats == nil {
+			curr.Repeats = make(map[string][]*scanArgs)
+		}
+		curr.Repeats[name] = append(curr.Repeats[name], set)
+		delete(curr.Sets, name)
+	}
+}
+
 func (s *scanner) isAlphabet(r rune) bool {
 	return ('a' <= r && r <= 'z') || 'A' <= r && r <= 'Z'
 }
 
+func (s *scanner) isValueFlag(f *FlagSet, name string) bool {
+	flag := f.searchFlag(name)
+	return flag != nil && !isBoolPtr(flag.Ptr)
+}
+
 func (s *scanner) checkSplits(f *FlagSet, rs []rune) (allFlag, firstFlag bool) {
 	allFlag = true
 	for i, r := range rs {
-		isFlag := f.isFlagOrSubset("-" + string(r))
+		name := "-" + string(r)
+		isFlag := f.isFlagOrSubset(name)
 		if isFlag {
-			if i == 0 && s.isAlphabet(r) && !s.isAlphabet(rs[i+1]) {
+			// value flag could take the remain characters as it's value, e.g. '-fa.tgz'
+			if i == 0 && len(rs) > 1 && (s.isValueFlag(f, name) || (s.isAlphabet(r) && !s.isAlphabet(rs[i+1]))) {
 				firstFlag = true
 			}
 		} else {
@@ -111,9 +156,36 @@ func (s *scanner) tryAppendFlagOrSubset(f *FlagSet, arg argument, mustAppend boo
 		if !isFlag && !isSubset {
 			return false, true
 		}
+		isHelp := isFlag && s.isHelpFlag(currSet, arg.Value)
+		if isHelp && s.HelpStack == nil {
+			s.HelpStack = []string{}
+			for _, subset := range s.SubsetStack {
+				if isEnableFlag(subset) {
+					break
+				}
+				s.HelpStack = append(s.HelpStack, subset)
+			}
+		}
+		if isFlag && i < len(s.SubsetStack) && (currSet.globalFlags || isHelp) {
+			// global flag of ancestor is appended to it without leaving current subset, help flags
+			// are always global to show help of current subset, e.g. 'tool sub -h --name x'
+			arg.Type = argumentFlag
+			s.appendArgTo(s.SubsetStack[:i], arg, false)
+			flag := currSet.searchFlag(arg.Value)
+			if !arg.AttachValid && !isBoolPtr(flag.Ptr) && !flag.AttachOnly {
+				s.globalStack, s.globalValues = s.SubsetStack[:i], 1
+				if flag.Nargs > 1 {
+					s.globalValues = flag.Nargs
+				}
+			}
+			return true, false
+		}
 
 		s.SubsetStack = s.SubsetStack[:i]
 		if isSubset {
+			if currSet.subsets[currSet.subsetIndexes[arg.Value]].repeatSlice != nil {
+				s.repeatSubset(s.SubsetStack, arg.Value)
+			}
 			s.SubsetStack = append(s.SubsetStack, arg.Value)
 		}
 		arg.Type = argumentFlag
@@ -122,6 +194,11 @@ func (s *scanner) tryAppendFlagOrSubset(f *FlagSet, arg argument, mustAppend boo
 	})
 }
 
+func (s *scanner) isHelpFlag(f *FlagSet, name string) bool {
+	flag := f.searchFlag(name)
+	return flag != nil && (flag.Ptr == interface{}(&f.help.showHelp) || flag.Ptr == interface{}(&f.help.verboseLevel))
+}
+
 func (s *scanner) appendSplittable(f *FlagSet, arg argument) {
 	flagRunes := []rune(arg.Value[1:])
 	s.reverseIterStack(f, func(currSet *FlagSet, i int) (result, continu bool) {
@@ -137,16 +214,16 @@ func (s *scanner) appendSplittable(f *FlagSet, arg argument) {
 		if allFlag {
 			for i, r := range flagRunes {
 				if i == len(flagRunes)-1 {
-					s.appendArg(argument{Type: argumentFlag, Value: "-" + string(r), Attached: arg.Attached, AttachValid: arg.AttachValid}, false)
+					s.appendArg(argument{Type: argumentFlag, Value: "-" + string(r), Attached: arg.Attached, AttachValid: arg.AttachValid, Index: arg.Index}, false)
 				} else {
-					s.appendArg(argument{Type: argumentFlag, Value: "-" + string(r)}, false)
+					s.appendArg(argument{Type: argumentFlag, Value: "-" + string(r), Cluster: arg.Value, Index: arg.Index}, false)
 				}
 			}
 			return false, false
 		}
 		if firstFlag && !arg.AttachValid {
-			s.appendArg(argument{Type: argumentFlag, Value: "-" + string(flagRunes[0])}, false)
-			s.appendArg(argument{Type: argumentValue, Value: string(flagRunes[1:])}, false)
+			s.appendArg(argument{Type: argumentFlag, Value: "-" + string(flagRunes[0]), Index: arg.Index}, false)
+			s.appendArg(argument{Type: argumentValue, Value: string(flagRunes[1:]), Index: arg.Index}, false)
 			return false, false
 		}
 		return false, true
@@ -156,14 +233,78 @@ func (s *scanner) appendSplittable(f *FlagSet, arg argument) {
 func (s *scanner) append(f *FlagSet, arg argument) {
 	switch arg.Type {
 	case argumentValue:
+		s.leaveEnabledSets(f)
 		s.appendArg(arg, false)
 	case argumentFlag, argumentPending:
 		s.tryAppendFlagOrSubset(f, arg, true)
 	case argumentFlagSplittable:
-		s.appendSplittable(f, arg)
+		if s.stackTopFlagSet(f, s.SubsetStack).noBundling {
+			arg.Type = argumentFlag
+			s.tryAppendFlagOrSubset(f, arg, true)
+		} else {
+			s.appendSplittable(f, arg)
+		}
+	}
+}
+
+// leaveEnabledSets pop subsets enabled by flag from stack if they couldn't accept non-flag value,
+// then the value belongs to parent.
+func (s *scanner) leaveEnabledSets(f *FlagSet) {
+	for l := len(s.SubsetStack); l > 0 && isEnableFlag(s.SubsetStack[l-1]); l-- {
+		if s.expectValue(f) || s.stackTopFlagSet(f, s.SubsetStack).acceptNonFlag() {
+			return
+		}
+		s.SubsetStack = s.SubsetStack[:l-1]
 	}
 }
 
+// isEnableFlag report whether the name in subset stack is a flag enabling subset rather than a
+// subcommand.
+func isEnableFlag(name string) bool {
+	return strings.HasPrefix(name, "-")
+}
+
+func (s *scanner) isNegativeNumber(arg string) bool {
+	if len(arg) < 2 || arg[0] != '-' || !(arg[1] == '.' || ('0' <= arg[1] && arg[1] <= '9')) {
+		return false
+	}
+	_, err := strconv.ParseFloat(arg, 64)
+	return err == nil
+}
+
+// expectValue report whether the last scanned flag is still waiting for value, flag with nargs
+// waits until all of it's values are scanned.
+func (s *scanner) expectValue(f *FlagSet) bool {
+	curr := &s.Result
+	for _, subset := range s.SubsetStack {
+		curr = curr.Sets[subset]
+		if curr == nil {
+			return false
+		}
+	}
+	i := len(curr.Flags) - 1
+	for i >= 0 && curr.Flags[i].Type == argumentValue {
+		i--
+	}
+	if i < 0 || curr.Flags[i].Type != argumentFlag {
+		return false
+	}
+	last := curr.Flags[i]
+	values := len(curr.Flags) - 1 - i
+	if last.AttachValid {
+		values++
+	}
+	flag := s.stackTopFlagSet(f, s.SubsetStack).searchFlag(last.Value)
+	if flag == nil || isBoolPtr(flag.Ptr) || flag.AttachOnly {
+		return false
+	}
+	nargs := flag.Nargs
+	if nargs == 0 {
+		nargs = 1
+	}
+	return values < nargs
+}
+
 func (s *scanner) canBeSplitBy(arg, sep string) bool {
 	index := strings.Index(arg, sep)
 	return index > 0 && index <= len(arg)-1
@@ -174,17 +315,17 @@ func (s *scanner) scanArg(f *FlagSet, args []string, i int) (consumed int) {
 	consumed = 1
 	switch {
 	case i == 0:
-		s.append(f, argument{Type: argumentFlag, Value: curr})
+		s.append(f, argument{Type: argumentFlag, Value: curr, Index: i})
 	case curr == "--":
 		if i != len(args)-1 {
 			curr = args[i+1]
 			consumed++
-			s.append(f, argument{Type: argumentValue, Value: curr})
+			s.append(f, argument{Type: argumentValue, Value: curr, Index: i + 1})
 		}
 	case curr == "--*":
 		for j := i + 1; j < len(args); j++ {
 			curr = args[j]
-			s.append(f, argument{Type: argumentValue, Value: curr})
+			s.append(f, argument{Type: argumentValue, Value: curr, Index: j})
 			consumed++
 		}
 	case strings.HasPrefix(curr, "-") && s.canBeSplitBy(curr[1:], "="):
@@ -193,20 +334,80 @@ func (s *scanner) scanArg(f *FlagSet, args []string, i int) (consumed int) {
 		if !strings.HasPrefix(secs[0], "--") && len(secs[0]) >= 3 {
 			typ = argumentFlagSplittable
 		}
-		s.append(f, argument{Type: typ, Value: secs[0], Attached: secs[1], AttachValid: true})
+		s.append(f, argument{Type: typ, Value: secs[0], Attached: secs[1], AttachValid: true, Index: i})
 	case strings.HasPrefix(curr, "--"):
-		s.append(f, argument{Type: argumentFlag, Value: curr})
-	case curr != flagNamePositional && s.tryAppendFlagOrSubset(f, argument{Type: argumentPending, Value: curr}, false):
+		s.append(f, argument{Type: argumentFlag, Value: curr, Index: i})
+	case s.isNegativeNumber(curr) && s.expectValue(f):
+		// negative number is the value of previous flag, e.g. '--offset -5'
+		s.append(f, argument{Type: argumentValue, Value: curr, Index: i})
+	case curr != flagNamePositional && s.tryAppendFlagOrSubset(f, argument{Type: argumentPending, Value: curr, Index: i}, false):
 	case curr != "-" && strings.HasPrefix(curr, "-"):
-		s.append(f, argument{Type: argumentFlagSplittable, Value: curr})
+		s.append(f, argument{Type: argumentFlagSplittable, Value: curr, Index: i})
 	default:
-		s.append(f, argument{Type: argumentValue, Value: curr})
+		s.append(f, argument{Type: argumentValue, Value: curr, Index: i})
 	}
 	return
 }
 
 func (s *scanner) scan(f *FlagSet, args []string) {
 	for i, l := 0, len(args); i < l; {
+		if s.globalValues > 0 {
+			s.appendArgTo(s.globalStack, argument{Type: argumentValue, Value: args[i], Index: i}, false)
+			s.globalValues--
+			i++
+			continue
+		}
 		i += s.scanArg(f, args, i)
+		if len(s.SubsetStack) > 0 && s.stackTopFlagSet(f, s.SubsetStack).self.Raw {
+			// arguments after raw subset are non-flag values verbatim
+			for ; i < l; i++ {
+				s.appendArg(argument{Type: argumentValue, Value: args[i], Index: i}, false)
+			}
+		}
+	}
+}
+
+// ScanToken is a token scanned from command line.
+type ScanToken struct {
+	Value  string // name of flag or command, or value
+	IsFlag bool   // whether the token is a flag or command name, the first token of each command is it's name
+
+	Attached    string // value attached to flag by '='
+	HasAttached bool   // whether the value is attached by '='
+
+	Cluster string // the original short flag cluster if the flag is splitted from it and is not the last one
+}
+
+// ScanResult is the exported view of scanning result, it contains ordered tokens of each command.
+type ScanResult struct {
+	Tokens  []ScanToken            // tokens of current command, the first one is command name
+	Path    []string               // detected subcommand path from current command, excluding itself
+	Subsets map[string]*ScanResult // scanned subcommands, keyed by the name typed in command line
+	Subcmd  string                 // the first subcommand, it's the head of Path
+}
+
+func newScanResult(args *scanArgs) *ScanResult {
+	result := &ScanResult{
+		Tokens: make([]ScanToken, 0, len(args.Flags)),
+		Subcmd: args.FirstSubset,
+	}
+	for _, arg := range args.Flags {
+		result.Tokens = append(result.Tokens, ScanToken{
+			Value:       arg.Value,
+			IsFlag:      arg.Type == argumentFlag,
+			Attached:    arg.Attached,
+			HasAttached: arg.AttachValid,
+			Cluster:     arg.Cluster,
+		})
+	}
+	for name, sub := range args.Sets {
+		if result.Subsets == nil {
+			result.Subsets = make(map[string]*ScanResult)
+		}
+		result.Subsets[name] = newScanResult(sub)
+	}
+	if result.Subcmd != "" {
+		result.Path = append([]string{result.Subcmd}, result.Subsets[result.Subcmd].Path...)
 	}
+	return result
 }
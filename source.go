@@ -0,0 +1,107 @@
+package flag
+
+import (
+	"context"
+	"os"
+)
+
+type sourceKind uint8
+
+const (
+	sourceKindConfigFile sourceKind = iota + 1
+	sourceKindEnv
+	sourceKindArgs
+)
+
+// Source is a value source of ParseWith.
+type Source struct {
+	kind sourceKind
+	path string
+	args []string
+}
+
+// ConfigFileSource create source loading values from config file, the format is detected by
+// file extension like ParseConfigFile.
+func ConfigFileSource(path string) Source {
+	return Source{kind: sourceKindConfigFile, path: path}
+}
+
+// EnvSource create source reading values from environment variables defined by the env tag.
+func EnvSource() Source {
+	return Source{kind: sourceKindEnv}
+}
+
+// ArgsSource create source parsing command line arguments, if empty, os.Args will be used.
+func ArgsSource(args ...string) Source {
+	return Source{kind: sourceKindArgs, args: args}
+}
+
+// sourceRanks is the precedence of environment and command line, higher rank overrides lower one,
+// zero means the source is not used. Rank of config file is stored in each flag.
+type sourceRanks struct {
+	env  int
+	args int
+}
+
+const defaultConfigRank = 1
+
+// defaultRanks is the precedence of Parse: command line > environment > config file > default.
+var defaultRanks = sourceRanks{env: defaultConfigRank + 1, args: defaultConfigRank + 2}
+
+// ParseWith parse values from sources in order, values of later sources override earlier ones,
+// default value is used if flag is not provided by any source. Sources not listed are not used, e.g.
+// environment is not read if EnvSource is absent, and config values loaded before are discarded.
+func (f *FlagSet) ParseWith(sources ...Source) error {
+	f.clearConfig()
+
+	var (
+		ranks sourceRanks
+		args  []string
+	)
+	for i, src := range sources {
+		rank := i + 1
+		switch src.kind {
+		case sourceKindConfigFile:
+			vals, err := f.readConfigFile(src.path)
+			if err == nil {
+				err = loadConfig(f, nil, vals, rank)
+			}
+			if err != nil {
+				return f.errorHandling.handle(err)
+			}
+		case sourceKindEnv:
+			ranks.env = rank
+		case sourceKindArgs:
+			ranks.args = rank
+			args = src.args
+			if len(args) == 0 {
+				args = os.Args
+			}
+		}
+	}
+	if ranks.args == 0 {
+		args = []string{f.self.Names}
+	}
+	return f.parse(context.Background(), args, ranks)
+}
+
+func (f *FlagSet) clearConfig() {
+	for i := range f.flags {
+		f.flags[i].configVals = nil
+		f.flags[i].configRank = 0
+	}
+	for i := range f.subsets {
+		f.subsets[i].clearConfig()
+	}
+}
+
+// resetSources clear value sources of last parsing, they are per-parse state, otherwise flags set by
+// command line in previous parsing are treated as set again and their default values are not applied.
+func (f *FlagSet) resetSources() {
+	for i := range f.flags {
+		f.flags[i].source = sourceNone
+	}
+	for i := range f.subsets {
+		f.subsets[i].resetSources()
+	}
+}
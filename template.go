@@ -0,0 +1,173 @@
+package flag
+
+import (
+	"io/ioutil"
+	"strings"
+	"text/template"
+)
+
+// DefaultHelpTemplate is the help template similar to the builtin help message, it can be
+// used as a start point of custom template. Columns are separated by '\t' and aligned.
+const DefaultHelpTemplate = `{{if .Usage}}{{.Usage}}
+
+{{end}}{{.Labels.Usage}} {{.Names}} {{.Arglist}}
+{{if .Version}}
+{{.Labels.Version}}
+{{range .Version}}	{{.}}
+{{end}}{{end}}{{if .Desc}}
+{{.Labels.Description}}
+{{range .Desc}}	{{.}}
+{{end}}{{end}}{{if .Flags}}
+{{.Labels.Flags}}
+{{range .Flags}}	{{.Info}}	{{.Usage}}	{{.ValueInfo}}
+{{range .Desc}}		{{.}}
+{{end}}{{end}}{{end}}{{if .Commands}}
+{{.Labels.Commands}}
+{{range .Commands}}{{$indent := .Indent}}{{$indent}}	{{.Names}}	{{.Usage}}
+{{range .Flags}}{{$indent}}		{{.Info}}	{{.Usage}}	{{.ValueInfo}}
+{{range .Desc}}{{$indent}}			{{.}}
+{{end}}{{end}}{{end}}{{end}}`
+
+// HelpFlag is the flag data used to render help template.
+type HelpFlag struct {
+	Names     string
+	Arglist   string
+	Info      string // names and arglist
+	Usage     string
+	Desc      []string
+	Type      string
+	Env       string
+	Default   string
+	Selects   string
+	ValueInfo string // type, env, default and selects info
+}
+
+// HelpCommand is the subcommand data used to render help template.
+type HelpCommand struct {
+	Names  string
+	Usage  string
+	Desc   []string
+	Indent string     // tabs of nested depth, it's empty for direct subcommands
+	Flags  []HelpFlag // flags of expanded subcommand
+}
+
+// HelpData is the data used to render help template.
+type HelpData struct {
+	Names    string
+	Usage    string
+	Arglist  string
+	Version  []string
+	Desc     []string
+	Flags    []HelpFlag
+	Commands []HelpCommand // subcommands, expanded subcommands are followed by their subcommands
+	HasArgs  bool          // whether non-flag arguments are accepted
+	Labels   HelpLabels    // section titles
+}
+
+// SetHelpTemplate set the text/template used to render help message of the flagset and it's subsets,
+// the template is executed with HelpData. If template is empty, the builtin help writer is used.
+// The template is checked by executing it for the flagset and it's subsets, so it should be set
+// after flags and subsets are registered.
+func (f *FlagSet) SetHelpTemplate(tmpl string) error {
+	var t *template.Template
+	if tmpl != "" {
+		var err error
+		t, err = template.New("help").Funcs(template.FuncMap{
+			"join": strings.Join,
+		}).Parse(tmpl)
+		if err != nil {
+			return f.errorHandling.handle(newErrorf(errInvalidValue, "invalid help template: %s", err.Error()))
+		}
+		err = checkHelpTemplate(t, f)
+		if err != nil {
+			return f.errorHandling.handle(err)
+		}
+	}
+	f.setHelpTemplate(t)
+	return nil
+}
+
+// checkHelpTemplate execute template for flagset and it's subsets with all subcommands expanded.
+func checkHelpTemplate(t *template.Template, f *FlagSet) error {
+	err := t.Execute(ioutil.Discard, newHelpData(f, -1))
+	if err != nil {
+		return newErrorf(errInvalidValue, "invalid help template: %s, %s", f.self.Names, err.Error())
+	}
+	for i := range f.subsets {
+		err = checkHelpTemplate(t, &f.subsets[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FlagSet) setHelpTemplate(t *template.Template) {
+	f.helpTemplate = t
+	for i := range f.subsets {
+		f.subsets[i].setHelpTemplate(t)
+	}
+}
+
+// newHelpData return the help template data, subcommands are expanded recursively if depth is less
+// than verbose level like the builtin help writer.
+func newHelpData(f *FlagSet, verboseLevel int) HelpData {
+	normal, positional := splitPositionalFlags(f)
+	return HelpData{
+		Names:    f.self.Names,
+		Usage:    f.self.Usage,
+		Arglist:  usageArglist(f, normal, positional),
+		Version:  f.self.versionLines,
+		Desc:     f.self.descLines,
+		Flags:    newHelpFlags(f),
+		Commands: appendHelpCommands(nil, f, "", 0, verboseLevel),
+		HasArgs:  f.self.argsPtr() != nil || len(positional) > 0,
+		Labels:   f.labels(),
+	}
+}
+
+func newHelpFlags(f *FlagSet) []HelpFlag {
+	var flags []HelpFlag
+	for i := range f.flags {
+		flag := &f.flags[i]
+		hf := HelpFlag{
+			Names:     flag.Names,
+			Arglist:   flag.Arglist,
+			Info:      flagInfo(flag),
+			Usage:     flag.Usage,
+			Desc:      flag.descLines,
+			Type:      flagTypeName(flag),
+			Env:       flag.Env,
+			ValueInfo: flagValueInfo(flag),
+		}
+		if flag.Default != nil {
+			hf.Default = formatDefault(flag)
+		}
+		if flag.Selects != nil {
+			hf.Selects = formatValue(flag, flag.Selects)
+		}
+		flags = append(flags, hf)
+	}
+	return flags
+}
+
+func appendHelpCommands(cmds []HelpCommand, f *FlagSet, indent string, depth, verboseLevel int) []HelpCommand {
+	expand := verboseLevel < 0 || depth < verboseLevel
+	for i := range f.subsets {
+		set := &f.subsets[i]
+		cmd := HelpCommand{
+			Names:  set.self.Names,
+			Usage:  set.self.Usage,
+			Desc:   set.self.descLines,
+			Indent: indent,
+		}
+		if !expand {
+			cmds = append(cmds, cmd)
+			continue
+		}
+		cmd.Flags = newHelpFlags(set)
+		cmds = append(cmds, cmd)
+		cmds = appendHelpCommands(cmds, set, indent+"\t", depth+1, verboseLevel)
+	}
+	return cmds
+}
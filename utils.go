@@ -1,10 +1,17 @@
 package flag
 
 import (
+	"fmt"
+	"math"
+	"net"
+	"net/url"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 func isKindNumber(k reflect.Kind) bool {
@@ -28,7 +35,7 @@ func isKindNumber(k reflect.Kind) bool {
 
 func isKindCompatible(k1, k2 reflect.Kind) bool {
 	switch k1 {
-	case reflect.Bool, reflect.String:
+	case reflect.Bool, reflect.String, reflect.Struct:
 		return k2 == k1
 	}
 	return isKindNumber(k1) && isKindNumber(k2)
@@ -36,32 +43,238 @@ func isKindCompatible(k1, k2 reflect.Kind) bool {
 
 func sliceElemKind(val reflect.Value) reflect.Kind {
 	k := val.Kind()
-	if k == reflect.Slice {
+	switch k {
+	case reflect.Slice:
 		return val.Type().Elem().Kind()
+	case reflect.Map:
+		return val.Type().Key().Kind()
 	}
 	return k
 }
 
+// setKeys return sorted keys of string set which value is true.
+func setKeys(refval reflect.Value) []string {
+	keys := make([]string, 0, refval.Len())
+	for _, key := range refval.MapKeys() {
+		if refval.MapIndex(key).Bool() {
+			keys = append(keys, key.String())
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func isBoolPtr(ptr interface{}) bool {
-	switch ptr.(type) {
-	case *bool, *[]bool:
+	return kindOf(ptr).Elem() == KindBool
+}
+
+func isTimePtr(ptr interface{}) bool {
+	return kindOf(ptr).Elem() == KindTime
+}
+
+func isOptionalPtr(ptr interface{}) bool {
+	refval := reflect.ValueOf(ptr)
+	return refval.Kind() == reflect.Ptr && refval.Type().Elem().Kind() == reflect.Ptr
+}
+
+// probePtr return a new pointer of the value type for optional pointer such as **int,
+// it's used to detect the value type, otherwise the pointer itself is returned.
+func probePtr(ptr interface{}) interface{} {
+	if !isOptionalPtr(ptr) {
+		return ptr
+	}
+	return reflect.New(reflect.TypeOf(ptr).Elem().Elem()).Interface()
+}
+
+func isRefvalSlicePtr(refval reflect.Value) bool {
+	return refval.Kind() == reflect.Ptr && refval.Elem().Kind() == reflect.Slice
+}
+
+func timeLayout(layout string) string {
+	if layout == "" {
+		return time.RFC3339
+	}
+	return layout
+}
+
+func parseTime(val, layout string) (time.Time, error) {
+	return time.Parse(timeLayout(layout), val)
+}
+
+func formatValue(flag *Flag, val interface{}) string {
+	if flag.Rune && isKindNumber(reflect.ValueOf(val).Kind()) {
+		if n, err := strconv.ParseFloat(fmt.Sprint(val), 64); err == nil {
+			return string(rune(n))
+		}
+	}
+	if refval := reflect.ValueOf(val); !isParsedKind(FlagKind(flag)) {
+		// named types are formatted as base type to avoid their String method
+		if base, has := namedBaseTypes[refval.Kind()]; has && refval.Type() != base {
+			val = refval.Convert(base).Interface()
+		}
+	}
+	switch v := val.(type) {
+	case time.Time:
+		return v.Format(timeLayout(flag.Layout))
+	case url.URL:
+		return v.String()
+	case []byte:
+		if flag.Raw {
+			return string(v)
+		}
+	}
+
+	refval := reflect.ValueOf(val)
+	if refval.Kind() == reflect.Map {
+		return fmt.Sprint(setKeys(refval))
+	}
+	if FlagKind(flag).IsSlice() && refval.Type() == reflect.TypeOf(probePtr(flag.Ptr)).Elem() {
+		vals := make([]string, refval.Len())
+		for i := range vals {
+			vals[i] = formatValue(flag, refval.Index(i).Interface())
+		}
+		return fmt.Sprint(vals)
+	}
+	return fmt.Sprint(val)
+}
+
+const secretMask = "***"
+
+// formatDefault format default value for displaying, it's masked for secret flag.
+func formatDefault(flag *Flag) string {
+	if flag.Secret {
+		return secretMask
+	}
+	return formatValue(flag, flag.Default)
+}
+
+// flagTypeName return the type name of flag displayed in help message.
+func flagTypeName(flag *Flag) string {
+	if flag.Rune {
+		return strings.Replace(FlagKind(flag).String(), KindInt32.String(), "rune", 1)
+	}
+	return FlagKind(flag).String()
+}
+
+// runeValue convert single character value to it's code, it's used to parse value of rune flag
+// as int32.
+func runeValue(val string) (string, error) {
+	rs := []rune(val)
+	if len(rs) != 1 {
+		return "", newErrorf(errInvalidValue, "value should be a single character: %s", val)
+	}
+	return strconv.Itoa(int(rs[0])), nil
+}
+
+// percentValue convert percentage such as '50%' to ratio, value without '%' is kept as is.
+func percentValue(val string) (string, error) {
+	if !strings.HasSuffix(val, "%") {
+		return val, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(val, "%")), 64)
+	if err != nil {
+		return "", newErrorf(errInvalidValue, "invalid percentage: %s", val)
+	}
+	return strconv.FormatFloat(f/100, 'g', -1, 64), nil
+}
+
+// valueConverter return the function converting value of rune or percent flag to number before
+// parsing, it's nil for other flags.
+func valueConverter(flag *Flag) func(string) (string, error) {
+	switch {
+	case flag.Rune:
+		return runeValue
+	case flag.Percent:
+		return percentValue
+	}
+	return nil
+}
+
+// convertValues convert each value separated by flag.ValSep for slice flag.
+func convertValues(flag *Flag, val string, convert func(string) (string, error)) (string, error) {
+	if !FlagKind(flag).IsSlice() {
+		return convert(val)
+	}
+	vals := splitValues(val, flag.ValSep)
+	for i := range vals {
+		v, err := convert(vals[i])
+		if err != nil {
+			return "", err
+		}
+		vals[i] = v
+	}
+	return strings.Join(vals, flag.ValSep), nil
+}
+
+// isParsedKind report whether values of the kind are parsed by parseValue rather than the
+// number/bool/string conversion.
+func isParsedKind(k Kind) bool {
+	switch k.Elem() {
+	case KindTime, KindDuration, KindIP, KindURL:
 		return true
 	}
 	return false
 }
 
-func isSlicePtr(ptr interface{}) bool {
-	return isRefvalSlicePtr(reflect.ValueOf(ptr))
+func parseValue(flag *Flag, k Kind, val string) (interface{}, error) {
+	switch k.Elem() {
+	case KindTime:
+		return parseTime(val, flag.Layout)
+	case KindDuration:
+		return time.ParseDuration(val)
+	case KindIP:
+		ip := net.ParseIP(val)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address: %s", val)
+		}
+		return ip, nil
+	case KindURL:
+		u, err := url.Parse(val)
+		if err != nil {
+			return nil, err
+		}
+		return *u, nil
+	}
+	return nil, newErrorf(errInvalidType, "unsupported kind: %s", k)
 }
 
-func isRefvalSlicePtr(refval reflect.Value) bool {
-	return refval.Kind() == reflect.Ptr && refval.Elem().Kind() == reflect.Slice
+func parseValues(flag *Flag, k Kind, vals []string) (interface{}, error) {
+	slice := reflect.MakeSlice(reflect.TypeOf(flag.Ptr).Elem(), 0, len(vals))
+	for _, val := range vals {
+		v, err := parseValue(flag, k, val)
+		if err != nil {
+			return nil, err
+		}
+		slice = reflect.Append(slice, reflect.ValueOf(v))
+	}
+	return slice.Interface(), nil
 }
 
-func parseDefault(val, valsep string, ptr interface{}) (interface{}, error) {
+func parseDefault(flag *Flag, val string) (interface{}, error) {
 	if val == "" {
 		return nil, nil
 	}
+	if hasFlagRef(val) {
+		// flag references are resolved on parsing
+		return val, nil
+	}
+	if flag.Raw {
+		return []byte(val), nil
+	}
+	if isOptionalPtr(flag.Ptr) {
+		f := *flag
+		f.Ptr = probePtr(flag.Ptr)
+		return parseDefault(&f, val)
+	}
+	if convert := valueConverter(flag); convert != nil {
+		f := *flag
+		f.Rune, f.Percent = false, false
+		val, err := convertValues(flag, val, convert)
+		if err != nil {
+			return nil, newErrorf(errInvalidDefault, "invalid default value for flag %s: %s", flag.Names, err.Error())
+		}
+		return parseDefault(&f, val)
+	}
 
 	var (
 		defval  interface{}
@@ -69,20 +282,26 @@ func parseDefault(val, valsep string, ptr interface{}) (interface{}, error) {
 		invalid bool
 	)
 
-	refval := reflect.ValueOf(ptr).Elem()
-	switch refval.Kind() {
-	case reflect.String:
+	refval := reflect.ValueOf(flag.Ptr).Elem()
+	k := FlagKind(flag)
+	switch {
+	case isParsedKind(k) && k.IsSlice():
+		defval, err = parseValues(flag, k, splitValues(val, flag.ValSep))
+	case isParsedKind(k):
+		defval, err = parseValue(flag, k, val)
+	case refval.Kind() == reflect.String:
 		defval = val
-	case reflect.Bool:
+	case refval.Kind() == reflect.Bool:
 		b, e := parseBool(val, "false")
 		defval, err = b, e
-	default:
-		if invalid = !isKindNumber(refval.Kind()); !invalid {
-			f, e := strconv.ParseFloat(val, 64)
-			defval, err = f, e
+	case k == KindStringSet:
+		set := make(map[string]bool)
+		for _, v := range splitValues(val, flag.ValSep) {
+			set[v] = true
 		}
-	case reflect.Slice:
-		vals := splitAndTrimSpace(val, valsep)
+		defval = set
+	case refval.Kind() == reflect.Slice:
+		vals := splitValues(val, flag.ValSep)
 		switch k := sliceElemKind(refval); k {
 		case reflect.String:
 			defval = vals
@@ -95,6 +314,11 @@ func parseDefault(val, valsep string, ptr interface{}) (interface{}, error) {
 				defval, err = fs, e
 			}
 		}
+	default:
+		if invalid = !isKindNumber(refval.Kind()); !invalid {
+			f, e := strconv.ParseFloat(val, 64)
+			defval, err = f, e
+		}
 	}
 	if err != nil {
 		return defval, newErrorf(errInvalidDefault, err.Error())
@@ -169,14 +393,48 @@ func convertNumbersToFloats(val interface{}) []float64 {
 	return fs
 }
 
-func parseSelectsString(val, valsep string, ptr interface{}) (interface{}, error) {
+// formatSelects format selects of parsed kinds, they are compared with the formatted flag value.
+func formatSelects(flag *Flag, vals []string) ([]string, error) {
+	selects := make([]string, 0, len(vals))
+	for _, v := range vals {
+		pv, err := parseValue(flag, FlagKind(flag), v)
+		if err != nil {
+			return nil, newErrorf(errInvalidSelects, err.Error())
+		}
+		selects = append(selects, formatValue(flag, pv))
+	}
+	return selects, nil
+}
+
+func parseSelectsString(flag *Flag, val string) (interface{}, error) {
 	if val == "" {
 		return nil, nil
 	}
+	if isOptionalPtr(flag.Ptr) {
+		f := *flag
+		f.Ptr = probePtr(flag.Ptr)
+		return parseSelectsString(&f, val)
+	}
+
+	vals := splitAndTrimSpace(val, flag.ValSep)
+	if convert := valueConverter(flag); convert != nil {
+		for i := range vals {
+			v, err := convert(vals[i])
+			if err != nil {
+				return nil, newErrorf(errInvalidSelects, "invalid selects for flag %s: %s", flag.Names, err.Error())
+			}
+			vals[i] = v
+		}
+	}
+	if isParsedKind(FlagKind(flag)) {
+		return formatSelects(flag, vals)
+	}
 
-	refval := reflect.ValueOf(ptr).Elem()
-	vals := splitAndTrimSpace(val, valsep)
+	refval := reflect.ValueOf(flag.Ptr).Elem()
 	k := sliceElemKind(refval)
+	if flag.Raw {
+		k = reflect.String
+	}
 	switch {
 	case k == reflect.String:
 		return vals, nil
@@ -191,70 +449,26 @@ func parseSelectsString(val, valsep string, ptr interface{}) (interface{}, error
 }
 
 func typeName(ptr interface{}) string {
-	switch ptr.(type) {
-	case *int:
-		return "int"
-	case *int8:
-		return "int8"
-	case *int16:
-		return "int16"
-	case *int32:
-		return "int32"
-	case *int64:
-		return "int64"
-	case *[]int:
-		return "[]int"
-	case *[]int8:
-		return "[]int8"
-	case *[]int16:
-		return "[]int16"
-	case *[]int32:
-		return "[]int32"
-	case *[]int64:
-		return "[]int64"
-	case *uint:
-		return "uint"
-	case *uint8:
-		return "uint8"
-	case *uint16:
-		return "uint16"
-	case *uint32:
-		return "uint32"
-	case *uint64:
-		return "uint64"
-	case *[]uint:
-		return "[]uint"
-	case *[]uint8:
-		return "[]uint8"
-	case *[]uint16:
-		return "[]uint16"
-	case *[]uint32:
-		return "[]uint32"
-	case *[]uint64:
-		return "[]uint64"
-	case *float32:
-		return "float32"
-	case *float64:
-		return "float64"
-	case *[]float32:
-		return "[]float32"
-	case *[]float64:
-		return "[]float64"
-	case *string:
-		return "string"
-	case *[]string:
-		return "[]string"
-	case *bool:
-		return "bool"
-	case *[]bool:
-		return "[]bool"
+	k := kindOf(ptr)
+	if k == KindInvalid {
+		return ""
 	}
-	return "unknown"
+	return k.String()
 }
 
-func checkSelects(k reflect.Kind, selects interface{}, val string, flt float64) bool {
+// checkSelects report whether value is one of selects, float values are equal if their difference is
+// not greater than tolerance.
+func checkSelects(k reflect.Kind, selects interface{}, val string, flt, tolerance float64) bool {
 	var valid bool
 	switch {
+	case k == reflect.Float32 || k == reflect.Float64:
+		vals, _ := selects.([]float64)
+		for _, v := range vals {
+			valid = valid || math.Abs(flt-v) <= tolerance
+			if valid {
+				break
+			}
+		}
 	case isKindNumber(k):
 		vals, _ := selects.([]float64)
 		for _, v := range vals {
@@ -275,18 +489,99 @@ func checkSelects(k reflect.Kind, selects interface{}, val string, flt float64)
 	return valid
 }
 
-func applyValToPtr(names string, ptr interface{}, val string, selects interface{}) error {
-	var err error
+// growSlice grow capacity of slice pointed by ptr to hold n more elements, it avoids reallocating
+// when appending lots of values one by one. Pointers to other types are ignored.
+func growSlice(ptr interface{}, n int) {
+	refval := reflect.ValueOf(ptr)
+	if refval.Kind() != reflect.Ptr {
+		return
+	}
+	refval = refval.Elem()
+	if refval.Kind() != reflect.Slice || refval.Cap()-refval.Len() >= n {
+		return
+	}
+	grown := reflect.MakeSlice(refval.Type(), refval.Len(), refval.Len()+n)
+	reflect.Copy(grown, refval)
+	refval.Set(grown)
+}
+
+func applyValToPtr(flag *Flag, val string) error {
+	if isOptionalPtr(flag.Ptr) {
+		refval := reflect.ValueOf(flag.Ptr).Elem()
+		if refval.IsNil() {
+			refval.Set(reflect.New(refval.Type().Elem()))
+		}
+		f := *flag
+		f.Ptr = refval.Interface()
+		return applyValToPtr(&f, val)
+	}
+	if flag.Clearable && val == "" {
+		// explicit empty value clears the slice
+		refval := reflect.ValueOf(flag.Ptr).Elem()
+		if refval.Kind() == reflect.Map {
+			refval.Set(reflect.MakeMap(refval.Type()))
+		} else {
+			refval.Set(reflect.MakeSlice(refval.Type(), 0, 0))
+		}
+		return nil
+	}
+	if convert := valueConverter(flag); convert != nil {
+		v, err := convert(val)
+		if err != nil {
+			return newErrorf(errInvalidValue, "%s: %s", flag.Names, err.Error())
+		}
+		val = v
+	}
+
+	var (
+		names   = flag.Names
+		ptr     = flag.Ptr
+		selects = flag.Selects
+		deny    = flag.DenySelects
+		err     error
+	)
 	if isBoolPtr(ptr) {
 		val, err = parsePossibleBoolValue(val)
 		if err != nil {
 			return newErrorf(errInvalidValue, "%s: %s", names, err.Error())
 		}
+		if flag.Invert {
+			val = strconv.FormatBool(val == "false")
+		}
+	}
+
+	if flag.SelectsCI {
+		vals, _ := selects.([]string)
+		denied, _ := deny.([]string)
+	normalize:
+		for _, list := range [][]string{vals, denied} {
+			for _, v := range list {
+				if strings.EqualFold(v, val) {
+					val = v
+					break normalize
+				}
+			}
+		}
 	}
 
-	flt, ferr := strconv.ParseFloat(val, 64)
-	bl, berr := strconv.ParseBool(val)
+	// value of bool pointer has been normalized, only numeric pointers need parsing, float value
+	// is also kept for selects checking of named types
+	var (
+		flt  float64
+		ferr error
+	)
+	switch ptr.(type) {
+	case *string, *[]string, *map[string]bool, *bool, *[]bool:
+	default:
+		flt, ferr = strconv.ParseFloat(val, 64)
+	}
 	switch v := ptr.(type) {
+	case *[]byte:
+		if flag.Raw {
+			*v = []byte(val)
+		} else {
+			*v, err = append(*v, uint8(flt)), ferr
+		}
 	case *int:
 		*v, err = int(flt), ferr
 	case *int8:
@@ -323,8 +618,6 @@ func applyValToPtr(names string, ptr interface{}, val string, selects interface{
 		*v, err = append(*v, int64(flt)), ferr
 	case *[]uint:
 		*v, err = append(*v, uint(flt)), ferr
-	case *[]uint8:
-		*v, err = append(*v, uint8(flt)), ferr
 	case *[]uint16:
 		*v, err = append(*v, uint16(flt)), ferr
 	case *[]uint32:
@@ -339,12 +632,23 @@ func applyValToPtr(names string, ptr interface{}, val string, selects interface{
 		*v = val
 	case *[]string:
 		*v = append(*v, val)
+	case *map[string]bool:
+		if *v == nil {
+			*v = make(map[string]bool)
+		}
+		(*v)[val] = true
 	case *bool:
-		*v, err = bl, berr
+		*v = val == "true"
 	case *[]bool:
-		*v, err = append(*v, bl), berr
+		*v = append(*v, val == "true")
 	default:
-		err = newErrorf(errInvalidType, "unsupported flag pointer type: %s %v", names, ptr)
+		if k := FlagKind(flag); isParsedKind(k) {
+			val, err = applyParsedValToPtr(flag, k, val)
+		} else if base := namedBaseType(ptr); base != nil {
+			err = applyNamedValToPtr(flag, base, val)
+		} else {
+			err = newErrorf(errInvalidType, "unsupported flag pointer type: %s %v", names, ptr)
+		}
 	}
 	if err != nil {
 		if _, ok := err.(flagError); !ok {
@@ -352,16 +656,70 @@ func applyValToPtr(names string, ptr interface{}, val string, selects interface{
 		}
 		return err
 	}
-	if selects != nil {
+	if flag.Min != nil && flt < flag.Min.(float64) {
+		return newErrorf(errInvalidValue, "%s: value %s is less than min %v", names, val, flag.Min)
+	}
+	if flag.Max != nil && flt > flag.Max.(float64) {
+		return newErrorf(errInvalidValue, "%s: value %s is greater than max %v", names, val, flag.Max)
+	}
+	if selects != nil || deny != nil {
 		refval := reflect.ValueOf(ptr).Elem()
 		k := sliceElemKind(refval)
-		if !checkSelects(k, selects, val, flt) {
+		if flag.Raw || isParsedKind(FlagKind(flag)) {
+			k = reflect.String
+		}
+		if selects != nil && !checkSelects(k, selects, val, flt, flag.tolerance) {
 			return newErrorf(errInvalidValue, "%s: invalid value %s of %v", names, val, selects)
 		}
+		if deny != nil && checkSelects(k, deny, val, flt, flag.tolerance) {
+			return newErrorf(errInvalidValue, "%s: value %s is denied by %v", names, val, deny)
+		}
 	}
 	return err
 }
 
+// applyParsedValToPtr apply value of parsed kinds and return the formatted value for selects checking.
+func applyParsedValToPtr(flag *Flag, k Kind, val string) (string, error) {
+	v, err := parseValue(flag, k, val)
+	if err != nil {
+		return val, err
+	}
+	refval := reflect.ValueOf(flag.Ptr).Elem()
+	if k.IsSlice() {
+		refval.Set(reflect.Append(refval, reflect.ValueOf(v)))
+	} else {
+		refval.Set(reflect.ValueOf(v))
+	}
+	return formatValue(flag, v), nil
+}
+
+// applyNamedValToPtr apply value to pointer of named type through a pointer of it's base type,
+// selects are checked by the caller.
+func applyNamedValToPtr(flag *Flag, base reflect.Type, val string) error {
+	var (
+		refval  = reflect.ValueOf(flag.Ptr).Elem()
+		isSlice = refval.Kind() == reflect.Slice
+		tmp     = reflect.New(base)
+		f       = *flag
+	)
+	f.Ptr, f.Selects, f.DenySelects, f.SelectsCI = tmp.Interface(), nil, nil, false
+	f.Rune, f.Percent, f.Clearable, f.Invert = false, false, false, false
+	f.Min, f.Max = nil, nil
+	if !isSlice {
+		tmp.Elem().Set(refval.Convert(base))
+	}
+	err := applyValToPtr(&f, val)
+	if err != nil {
+		return err
+	}
+	if isSlice {
+		refval.Set(reflect.Append(refval, tmp.Elem().Convert(refval.Type().Elem())))
+	} else {
+		refval.Set(tmp.Elem().Convert(refval.Type()))
+	}
+	return nil
+}
+
 func resetPtrVal(ptr interface{}) {
 	switch v := ptr.(type) {
 	case *int:
@@ -416,18 +774,112 @@ func resetPtrVal(ptr interface{}) {
 		*v = ""
 	case *[]string:
 		*v = nil
+	case *map[string]bool:
+		*v = nil
 	case *bool:
 		*v = false
 	case *[]bool:
 		*v = nil
+	default:
+		if isOptionalPtr(ptr) || isParsedKind(kindOf(ptr)) || namedBaseType(ptr) != nil {
+			refval := reflect.ValueOf(ptr).Elem()
+			refval.Set(reflect.Zero(refval.Type()))
+		}
+	}
+}
+
+// isAttachedSplittable report whether attached value of flag is splitted by ValSep, it's limited to
+// slices of plain string and number, values of time, url and rune flags may contain the separator.
+func isAttachedSplittable(flag *Flag) bool {
+	kind := FlagKind(flag)
+	if !kind.IsSlice() || flag.Split || flag.Raw || flag.Rune || flag.Nargs != 0 {
+		return false
+	}
+	elem := kind.Elem()
+	return elem == KindString || elem.isNumber()
+}
+
+// splitValues split environment or default value of slice flag by sep like splitAndTrimSpace,
+// separator could be escaped by '\', and it's kept inside quoted segment, e.g. `a,"b,c"` and
+// `a,b\,c` are both splitted to [a b,c]. Quote is only recognized at the beginning of segment.
+func splitValues(s, sep string) []string {
+	if sep == nulValSep {
+		return splitNul(s)
 	}
+	s = strings.TrimSpace(s)
+	if !strings.ContainsAny(s, "\\\"'") {
+		return splitAndTrimSpace(s, sep)
+	}
+
+	var (
+		vals      []string
+		sb        strings.Builder
+		quote     byte
+		keep      int // length of escaped or quoted content, it should not be trimmed
+		blankSep  = strings.TrimSpace(sep) == ""
+		isSpace   = func(c byte) bool { return c == ' ' || c == '\t' }
+		appendVal = func() {
+			val := sb.String()
+			val = val[:keep] + strings.TrimRight(val[keep:], " \t")
+			if val != "" || !blankSep {
+				vals = append(vals, val)
+			}
+			sb.Reset()
+			keep = 0
+		}
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			sb.WriteByte(s[i])
+			keep = sb.Len()
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				sb.WriteByte(c)
+			}
+			keep = sb.Len()
+		case (c == '"' || c == '\'') && sb.Len() == 0:
+			quote = c
+		case strings.HasPrefix(s[i:], sep):
+			appendVal()
+			i += len(sep) - 1
+		case isSpace(c) && sb.Len() == 0:
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	appendVal()
+	return vals
+}
+
+// nulValSep is the NUL separator, e.g. for output of 'find -print0'.
+const nulValSep = "\x00"
+
+// splitNul split NUL-delimited values, they are kept as is without trimming and unescaping,
+// the trailing NUL terminator is ignored.
+func splitNul(s string) []string {
+	s = strings.TrimSuffix(s, nulValSep)
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, nulValSep)
 }
 
 func splitAndTrimSpace(s, sep string) []string {
+	if sep == nulValSep {
+		return splitNul(s)
+	}
 	s = strings.TrimSpace(s)
 	if s == "" {
 		return nil
 	}
+	if strings.TrimSpace(sep) == "" {
+		return strings.Fields(s)
+	}
 	secs := strings.Split(s, sep)
 	for i := range secs {
 		secs[i] = strings.TrimSpace(secs[i])
@@ -452,6 +904,62 @@ func unexportedName(name string) string {
 	return name
 }
 
+// splitWords split camel case name to words, e.g. 'HTTPServer' to [HTTP Server], digits are kept in
+// the previous word.
+func splitWords(name string) []string {
+	var (
+		words []string
+		rs    = []rune(name)
+		start int
+	)
+	for i := 1; i < len(rs); i++ {
+		if !unicode.IsUpper(rs[i]) {
+			continue
+		}
+		if !unicode.IsUpper(rs[i-1]) || (i+1 < len(rs) && unicode.IsLower(rs[i+1])) {
+			words = append(words, string(rs[start:i]))
+			start = i
+		}
+	}
+	if start < len(rs) {
+		words = append(words, string(rs[start:]))
+	}
+	return words
+}
+
+// styledName convert field name to flag or command name by naming style.
+func styledName(style NamingStyle, name string) string {
+	if style == NamingDefault {
+		return unexportedName(name)
+	}
+	words := splitWords(name)
+	for i := range words {
+		words[i] = strings.ToLower(words[i])
+	}
+	switch style {
+	case NamingKebab:
+		return strings.Join(words, "-")
+	case NamingSnake:
+		return strings.Join(words, "_")
+	}
+	for i := 1; i < len(words); i++ {
+		rs := []rune(words[i])
+		rs[0] = unicode.ToUpper(rs[0])
+		words[i] = string(rs)
+	}
+	return strings.Join(words, "")
+}
+
+// styledFlagName convert field name to flag name by naming style, names generated by styles other
+// than NamingDefault use '--' prefix if they have more than one character.
+func styledFlagName(style NamingStyle, name string) string {
+	name = styledName(style, name)
+	if style != NamingDefault && utf8.RuneCountInString(name) > 1 {
+		return "--" + name
+	}
+	return "-" + name
+}
+
 func parsePossibleBoolValue(val string) (string, error) {
 	switch strings.ToLower(val) {
 	case "true", "t", "yes", "y", "1":
@@ -462,6 +970,12 @@ func parsePossibleBoolValue(val string) (string, error) {
 	return "", newErrorf(errInvalidValue, "illegal boolean value: %s", val)
 }
 
+// isBoolLiteral report whether the value is 'true' or 'false' case-insensitively, other forms
+// accepted by parsePossibleBoolValue such as '1' are not treated as bool when peeking next argument.
+func isBoolLiteral(val string) bool {
+	return strings.EqualFold(val, "true") || strings.EqualFold(val, "false")
+}
+
 func parseBool(val, defval string) (bool, error) {
 	if val == "" {
 		val = defval
@@ -496,3 +1010,153 @@ func convertToBools(vals []string) ([]bool, error) {
 	}
 	return bs, nil
 }
+
+func stringsContains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// structTagKeys return keys of the struct tag, the parsing rule is same as reflect.StructTag.Lookup.
+func structTagKeys(tag reflect.StructTag) []string {
+	var keys []string
+	for tag != "" {
+		i := 0
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		tag = tag[i:]
+		if tag == "" {
+			break
+		}
+
+		i = 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			break
+		}
+		keys = append(keys, string(tag[:i]))
+		tag = tag[i+1:]
+
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			break
+		}
+		tag = tag[i+1:]
+	}
+	return keys
+}
+
+func hasFlagRef(s string) bool {
+	return strings.Contains(s, "${")
+}
+
+// expandFlagRefs replace ${name} in s with the value returned by mapping.
+func expandFlagRefs(s string, mapping func(name string) (string, error)) (string, error) {
+	var sb strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		end := strings.IndexByte(s[i+2:], '}')
+		if end < 0 {
+			break
+		}
+		val, err := mapping(s[i+2 : i+2+end])
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(s[:i])
+		sb.WriteString(val)
+		s = s[i+2+end+1:]
+	}
+	sb.WriteString(s)
+	return sb.String(), nil
+}
+
+// formatPtrValue format current value stored in flag pointer, slice values are joined by ValSep.
+func formatPtrValue(flag *Flag) string {
+	refval := reflect.ValueOf(flag.Ptr).Elem()
+	if isOptionalPtr(flag.Ptr) {
+		if refval.IsNil() {
+			return ""
+		}
+		refval = refval.Elem()
+	}
+	if flag.Invert {
+		return strconv.FormatBool(!refval.Bool())
+	}
+	if refval.Kind() == reflect.Map {
+		return strings.Join(setKeys(refval), flag.ValSep)
+	}
+	if FlagKind(flag).IsSlice() {
+		vals := make([]string, refval.Len())
+		for i := range vals {
+			vals[i] = formatValue(flag, refval.Index(i).Interface())
+		}
+		return strings.Join(vals, flag.ValSep)
+	}
+	return formatValue(flag, refval.Interface())
+}
+
+// editDistance return the levenshtein distance of two strings.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+const maxSuggestionDistance = 2
+
+// suggestion return the "did you mean" message of the most similar name, distance must not be greater
+// than maxSuggestionDistance, it's empty if no name is similar enough.
+func suggestion(name string, names map[string]int) string {
+	var (
+		best     string
+		bestDist = maxSuggestionDistance + 1
+	)
+	for candidate := range names {
+		dist := editDistance(name, candidate)
+		if dist < bestDist || (dist == bestDist && candidate < best) {
+			best, bestDist = candidate, dist
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return "; did you mean " + best + "?"
+}